@@ -0,0 +1,73 @@
+package ginprom
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultApdexMetricName = "apdex_requests_total"
+
+// Apdex classes of the requests.
+const (
+	apdexSatisfied  = "satisfied"
+	apdexTolerating = "tolerating"
+	apdexFrustrated = "frustrated"
+)
+
+// apdexThreshold returns the satisfied threshold of the given path, which is
+// zero when the path isn't subject to Apdex.
+func (p *Prometheus) apdexThreshold(path string) time.Duration {
+	if t, ok := p.apdexRoutes[path]; ok {
+		return t
+	}
+	return p.apdex
+}
+
+// apdexClass classifies a request served in the given duration against the
+// satisfied threshold t. Server errors are always frustrating.
+func apdexClass(status string, elapsed, t time.Duration) string {
+	switch {
+	case strings.HasPrefix(status, "5"):
+		return apdexFrustrated
+	case elapsed <= t:
+		return apdexSatisfied
+	case elapsed <= 4*t:
+		return apdexTolerating
+	default:
+		return apdexFrustrated
+	}
+}
+
+func validateApdex(global time.Duration, routes map[string]time.Duration) []error {
+	var errs []error
+	if global < 0 {
+		errs = append(errs, fmt.Errorf("apdex threshold must not be negative, got %v", global))
+	}
+	for _, path := range slices.Sorted(maps.Keys(routes)) {
+		if t := routes[path]; t <= 0 {
+			errs = append(errs, fmt.Errorf("apdex threshold of route %q must be positive, got %v", path, t))
+		}
+	}
+	return errs
+}
+
+func (p *Prometheus) registerApdex() {
+	p.apdexCnt = prometheus.NewCounterVec(
+		p.counterOpts(defaultApdexMetricName, "How many HTTP requests were satisfied, tolerating or frustrated according to the Apdex threshold, partitioned by method, path and host."),
+		[]string{"method", "path", "host", "class"},
+	)
+	p.mustRegister(p.apdexCnt)
+}
+
+func (p *Prometheus) observeApdex(method, path, host, status string, elapsed time.Duration) {
+	t := p.apdexThreshold(path)
+	if t <= 0 {
+		return
+	}
+	p.apdexCnt.WithLabelValues(method, path, host, apdexClass(status, elapsed, t)).Inc()
+}
@@ -0,0 +1,127 @@
+package ginprom
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultClientReqCntMetricName   = "client_requests_total"
+	defaultClientReqDurMetricName   = "client_request_duration"
+	defaultClientInFlightMetricName = "client_in_flight_requests"
+	defaultClientDNSDurMetricName   = "client_dns_duration"
+	defaultClientTLSDurMetricName   = "client_tls_duration"
+)
+
+// clientMetrics holds the metrics of the outgoing requests, registered the
+// first time RoundTripper is called.
+type clientMetrics struct {
+	once     sync.Once
+	reqCnt   *prometheus.CounterVec
+	reqDur   *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	dnsDur   *prometheus.HistogramVec
+	tlsDur   *prometheus.HistogramVec
+}
+
+func (p *Prometheus) registerClientMetrics() {
+	m := &p.client
+	m.once.Do(func() {
+		m.reqCnt = prometheus.NewCounterVec(
+			p.counterOpts(defaultClientReqCntMetricName, "How many outgoing HTTP requests were made, partitioned by target host, method, status code and path."),
+			[]string{"host", "method", "code", "path"},
+		)
+		m.reqDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultClientReqDurMetricName, "The outgoing HTTP request latency bucket, until the response headers are received.", p.BucketsSize),
+			[]string{"host", "method", "code", "path"},
+		)
+		m.inFlight = prometheus.NewGaugeVec(
+			p.gaugeOpts(defaultClientInFlightMetricName, "How many outgoing HTTP requests are currently in flight, partitioned by target host."),
+			[]string{"host"},
+		)
+		m.dnsDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultClientDNSDurMetricName, "The DNS lookup latency bucket of the outgoing HTTP requests.", p.BucketsSize),
+			[]string{"host"},
+		)
+		m.tlsDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultClientTLSDurMetricName, "The TLS handshake latency bucket of the outgoing HTTP requests.", p.BucketsSize),
+			[]string{"host"},
+		)
+		p.mustRegister(m.reqCnt, m.reqDur, m.inFlight, m.dnsDur, m.tlsDur)
+	})
+}
+
+// roundTripper instruments the requests going through the next RoundTripper.
+type roundTripper struct {
+	p        *Prometheus
+	next     http.RoundTripper
+	pathFunc func(r *http.Request) string
+}
+
+// RoundTripper returns an http.RoundTripper instrumenting the outgoing
+// requests with the namespace, subsystem and registry of the instance: their
+// count, duration until the response headers are received, the in-flight
+// requests, and the DNS lookup and TLS handshake durations. The path label is
+// the one returned by pathFunc, which should return a templated path such as
+// /users/:id to keep a low cardinality, and is empty if pathFunc is nil.
+// Requests failing without a response are recorded with the "error" code. The
+// http.DefaultTransport is used if next is nil.
+// Example:
+// client := &http.Client{Transport: p.RoundTripper(nil, func(r *http.Request) string { return "/users/:id" })}
+func (p *Prometheus) RoundTripper(next http.RoundTripper, pathFunc func(r *http.Request) string) http.RoundTripper {
+	p.registerClientMetrics()
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{p: p, next: next, pathFunc: pathFunc}
+}
+
+func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	m := &rt.p.client
+	host := r.URL.Host
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := ""
+	if rt.pathFunc != nil {
+		path = rt.pathFunc(r)
+	}
+
+	var dnsStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				m.dnsDur.WithLabelValues(host).Observe(time.Since(dnsStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			if !tlsStart.IsZero() {
+				m.tlsDur.WithLabelValues(host).Observe(time.Since(tlsStart).Seconds())
+			}
+		},
+	}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+
+	inFlight := m.inFlight.WithLabelValues(host)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	res, err := rt.next.RoundTrip(r)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	m.reqCnt.WithLabelValues(host, method, code, path).Inc()
+	m.reqDur.WithLabelValues(host, method, code, path).Observe(time.Since(start).Seconds())
+	return res, err
+}
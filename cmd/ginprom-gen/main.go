@@ -0,0 +1,106 @@
+// Command ginprom-gen generates a Prometheus rule file and a Grafana dashboard
+// for the RED metrics of a ginprom instance, from its declarative
+// configuration.
+//
+// Usage:
+//
+//	ginprom-gen -config ginprom.yml -rules rules.yml -dashboard dashboard.json
+//
+// The configuration is decoded as JSON when the file has a .json extension,
+// and as YAML otherwise. When neither -rules nor -dashboard is set, the rules
+// are written to the standard output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Depado/ginprom"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ginprom-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ginprom-gen", flag.ContinueOnError)
+	config := fs.String("config", "", "path to the ginprom configuration, defaults are used if empty")
+	env := fs.String("env", "", "prefix of the environment variables overriding the configuration, ignored if empty")
+	rules := fs.String("rules", "", `path of the generated rule file, "-" for the standard output`)
+	dashboard := fs.String("dashboard", "", `path of the generated Grafana dashboard, "-" for the standard output`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *rules == "" && *dashboard == "" {
+		*rules = "-"
+	}
+
+	c, err := loadConfig(*config, *env)
+	if err != nil {
+		return err
+	}
+
+	if *rules != "" {
+		f, err := ginprom.GenerateRules(c)
+		if err != nil {
+			return err
+		}
+		out, err := f.YAML()
+		if err != nil {
+			return err
+		}
+		if err := write(*rules, out, stdout); err != nil {
+			return err
+		}
+	}
+
+	if *dashboard != "" {
+		out, err := ginprom.GenerateDashboard(c)
+		if err != nil {
+			return err
+		}
+		if err := write(*dashboard, out, stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadConfig(path, env string) (ginprom.Config, error) {
+	var c ginprom.Config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			c, err = ginprom.ParseConfigJSON(data)
+		} else {
+			c, err = ginprom.ParseConfigYAML(data)
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	if env != "" {
+		if err := c.LoadEnv(env); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func write(path string, data []byte, stdout io.Writer) error {
+	if path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
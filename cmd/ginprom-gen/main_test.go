@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "ginprom.json")
+	assert.NoError(t, os.WriteFile(config, []byte(`{"namespace": "myapp"}`), 0o600))
+
+	var stdout bytes.Buffer
+	assert.NoError(t, run([]string{"-config", config}, &stdout))
+	assert.Contains(t, stdout.String(), "myapp_gonic_requests_total")
+
+	dashboard := filepath.Join(dir, "dashboard.json")
+	stdout.Reset()
+	assert.NoError(t, run([]string{"-config", config, "-dashboard", dashboard}, &stdout))
+	assert.Empty(t, stdout.String())
+	data, err := os.ReadFile(dashboard)
+	assert.NoError(t, err)
+	assert.True(t, json.Valid(data))
+
+	t.Setenv("TEST_REQUEST_COUNTER_METRIC_NAME", "invalid-name")
+	assert.Error(t, run([]string{"-env", "TEST"}, &stdout))
+}
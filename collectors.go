@@ -0,0 +1,211 @@
+package ginprom
+
+import (
+	"database/sql"
+	"errors"
+	"maps"
+	"runtime"
+	"runtime/debug"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var defaultBuildInfoMetricName = "build_info"
+var defaultRouteInfoMetricName = "route_info"
+var defaultRoutesMetricName = "routes"
+var defaultRouteChainMetricName = "route_chain_length"
+var defaultDBStatsPrefix = "db_"
+
+// registerRuntimeCollectors registers the Go runtime, process and build info
+// collectors. The Go runtime and process collectors may already be registered,
+// for example when using the default registry or when multiple instances share
+// a registry, in which case the existing ones are kept. A Go collector exposing
+// other runtime/metrics than the registered one conflicts with it and panics.
+func (p *Prometheus) registerRuntimeCollectors() {
+	registerer, _ := p.getRegistererAndGatherer()
+
+	registerShared(registerer, collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(p.runtimeMetricsRules...)))
+	registerShared(registerer, collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	p.mustRegister(newBuildInfoCollector(p))
+}
+
+// registerShared registers a collector unless an identical one is already
+// registered, panicking on any other error.
+func registerShared(registerer prometheus.Registerer, c prometheus.Collector) {
+	if err := registerer.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+	}
+}
+
+// buildInfoCollector exposes the module version and VCS information embedded
+// in the binary.
+type buildInfoCollector struct {
+	desc   *prometheus.Desc
+	labels []string
+}
+
+func newBuildInfoCollector(p *Prometheus) *buildInfoCollector {
+	path, version, revision, revisionTime, modified := "unknown", "unknown", "unknown", "unknown", "unknown"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		path, version = bi.Main.Path, bi.Main.Version
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.time":
+				revisionTime = s.Value
+			case "vcs.modified":
+				modified = s.Value
+			}
+		}
+	}
+
+	return &buildInfoCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(p.Namespace, p.Subsystem, defaultBuildInfoMetricName),
+			"Build information about the main module, with a constant value of 1.",
+			[]string{"path", "version", "revision", "revision_time", "modified", "go_version"},
+			p.ConstLabels,
+		),
+		labels: []string{path, version, revision, revisionTime, modified, runtime.Version()},
+	}
+}
+
+func (c *buildInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *buildInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, c.labels...)
+}
+
+// engineCollector exposes the routes registered on the gin engine of the
+// instance, read at scrape time.
+type engineCollector struct {
+	p *Prometheus
+
+	routeInfo   *prometheus.Desc
+	routes      *prometheus.Desc
+	chainLength *prometheus.Desc
+}
+
+func newEngineCollector(p *Prometheus) *engineCollector {
+	return &engineCollector{
+		p: p,
+		routeInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(p.Namespace, p.Subsystem, defaultRouteInfoMetricName),
+			"Routes registered on the gin engine, with a constant value of 1.",
+			[]string{"method", "path", "handler"},
+			p.ConstLabels,
+		),
+		routes: prometheus.NewDesc(
+			prometheus.BuildFQName(p.Namespace, p.Subsystem, defaultRoutesMetricName),
+			"How many routes are registered on the gin engine, partitioned by method.",
+			[]string{"method"},
+			p.ConstLabels,
+		),
+		chainLength: prometheus.NewDesc(
+			prometheus.BuildFQName(p.Namespace, p.Subsystem, defaultRouteChainMetricName),
+			"Length of the handlers chain (middlewares and handler) of the routes served at least once.",
+			[]string{"method", "path"},
+			p.ConstLabels,
+		),
+	}
+}
+
+func (c *engineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.routeInfo
+	ch <- c.routes
+	ch <- c.chainLength
+}
+
+func (c *engineCollector) Collect(ch chan<- prometheus.Metric) {
+	e := c.p.Engine
+	if e == nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, r := range e.Routes() {
+		counts[r.Method]++
+		ch <- prometheus.MustNewConstMetric(c.routeInfo, prometheus.GaugeValue, 1, r.Method, r.Path, r.Handler)
+		if n, ok := c.p.routeChainLength(r.Method, r.Path); ok {
+			ch <- prometheus.MustNewConstMetric(c.chainLength, prometheus.GaugeValue, float64(n), r.Method, r.Path)
+		}
+	}
+	for method, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.routes, prometheus.GaugeValue, float64(n), method)
+	}
+}
+
+// dbStatsCollector exposes the connection pool statistics of the named
+// databases, read at scrape time.
+type dbStatsCollector struct {
+	dbs map[string]*sql.DB
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newDBStatsCollector(p *Prometheus) *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(p.Namespace, p.Subsystem, defaultDBStatsPrefix+name),
+			help,
+			[]string{"db"},
+			p.ConstLabels,
+		)
+	}
+	return &dbStatsCollector{
+		dbs:               p.dbs,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "The number of established connections both in use and idle."),
+		inUse:             desc("in_use_connections", "The number of connections currently in use."),
+		idle:              desc("idle_connections", "The number of idle connections."),
+		waitCount:         desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns."),
+		maxIdleTimeClosed: desc("max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxIdleTimeClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range slices.Sorted(maps.Keys(c.dbs)) {
+		s := c.dbs[name].Stats()
+		ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(s.OpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse), name)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle), name)
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(s.MaxIdleClosed), name)
+		ch <- prometheus.MustNewConstMetric(c.maxIdleTimeClosed, prometheus.CounterValue, float64(s.MaxIdleTimeClosed), name)
+		ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(s.MaxLifetimeClosed), name)
+	}
+}
@@ -0,0 +1,305 @@
+package ginprom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config is the declarative configuration of an instance. It can be decoded
+// from YAML, JSON or environment variables and converted into options with
+// the Options method. Zero values are left to the defaults of New.
+type Config struct {
+	Path      string    `json:"path,omitempty" yaml:"path,omitempty"`
+	Namespace *string   `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Subsystem *string   `json:"subsystem,omitempty" yaml:"subsystem,omitempty"`
+	Token     string    `json:"token,omitempty" yaml:"token,omitempty"`
+	Buckets   []float64 `json:"buckets,omitempty" yaml:"buckets,omitempty"`
+	Ignore    []string  `json:"ignore,omitempty" yaml:"ignore,omitempty"`
+
+	NativeHistogram NativeHistogramConfig `json:"native_histogram" yaml:"native_histogram"`
+	MetricNames     MetricNamesConfig     `json:"metric_names" yaml:"metric_names"`
+}
+
+// NativeHistogramConfig is the native histogram part of Config.
+type NativeHistogramConfig struct {
+	Enabled          bool     `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Hybrid           bool     `json:"hybrid,omitempty" yaml:"hybrid,omitempty"`
+	BucketFactor     float64  `json:"bucket_factor,omitempty" yaml:"bucket_factor,omitempty"`
+	MaxBucketNumber  uint32   `json:"max_bucket_number,omitempty" yaml:"max_bucket_number,omitempty"`
+	MinResetDuration Duration `json:"min_reset_duration,omitempty" yaml:"min_reset_duration,omitempty"`
+	ZeroThreshold    float64  `json:"zero_threshold,omitempty" yaml:"zero_threshold,omitempty"`
+	MaxZeroThreshold float64  `json:"max_zero_threshold,omitempty" yaml:"max_zero_threshold,omitempty"`
+}
+
+// MetricNamesConfig is the metric names part of Config.
+type MetricNamesConfig struct {
+	RequestCounter  string `json:"request_counter,omitempty" yaml:"request_counter,omitempty"`
+	RequestDuration string `json:"request_duration,omitempty" yaml:"request_duration,omitempty"`
+	RequestSize     string `json:"request_size,omitempty" yaml:"request_size,omitempty"`
+	ResponseSize    string `json:"response_size,omitempty" yaml:"response_size,omitempty"`
+}
+
+// Duration is a time.Duration decoded from and encoded to strings such as
+// "1h30m".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ParseConfigYAML decodes a YAML configuration. Unknown fields are rejected.
+func ParseConfigYAML(data []byte) (Config, error) {
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return c, fmt.Errorf("decode yaml config: %w", err)
+	}
+	return c, nil
+}
+
+// ParseConfigJSON decodes a JSON configuration. Unknown fields are rejected.
+func ParseConfigJSON(data []byte) (Config, error) {
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("decode json config: %w", err)
+	}
+	return c, nil
+}
+
+// LoadEnv overrides the configuration with the environment variables starting
+// with the given prefix, for example GINPROM_PATH with the "GINPROM" prefix.
+// Lists (buckets and ignore) are comma-separated. The recognized variables
+// are PATH, NAMESPACE, SUBSYSTEM, TOKEN, BUCKETS, IGNORE, NATIVE_HISTOGRAM,
+// NATIVE_HISTOGRAM_HYBRID, NATIVE_HISTOGRAM_BUCKET_FACTOR, NATIVE_HISTOGRAM_MAX_BUCKET_NUMBER,
+// NATIVE_HISTOGRAM_MIN_RESET_DURATION, NATIVE_HISTOGRAM_ZERO_THRESHOLD,
+// NATIVE_HISTOGRAM_MAX_ZERO_THRESHOLD, REQUEST_COUNTER_METRIC_NAME,
+// REQUEST_DURATION_METRIC_NAME, REQUEST_SIZE_METRIC_NAME and
+// RESPONSE_SIZE_METRIC_NAME.
+func (c *Config) LoadEnv(prefix string) error {
+	var errs []error
+	lookup := func(name string) (string, bool) {
+		return os.LookupEnv(prefix + "_" + name)
+	}
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	parse := func(name string, f func(string) error) {
+		if v, ok := lookup(name); ok {
+			if err := f(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s_%s: %w", prefix, name, err))
+			}
+		}
+	}
+
+	str("PATH", &c.Path)
+	if v, ok := lookup("NAMESPACE"); ok {
+		c.Namespace = &v
+	}
+	if v, ok := lookup("SUBSYSTEM"); ok {
+		c.Subsystem = &v
+	}
+	str("TOKEN", &c.Token)
+	parse("BUCKETS", func(v string) error {
+		c.Buckets = nil
+		for _, s := range splitList(v) {
+			b, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			c.Buckets = append(c.Buckets, b)
+		}
+		return nil
+	})
+	if v, ok := lookup("IGNORE"); ok {
+		c.Ignore = splitList(v)
+	}
+
+	parse("NATIVE_HISTOGRAM", func(v string) (err error) {
+		c.NativeHistogram.Enabled, err = strconv.ParseBool(v)
+		return err
+	})
+	parse("NATIVE_HISTOGRAM_HYBRID", func(v string) (err error) {
+		c.NativeHistogram.Hybrid, err = strconv.ParseBool(v)
+		return err
+	})
+	parse("NATIVE_HISTOGRAM_BUCKET_FACTOR", func(v string) (err error) {
+		c.NativeHistogram.BucketFactor, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+	parse("NATIVE_HISTOGRAM_MAX_BUCKET_NUMBER", func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		c.NativeHistogram.MaxBucketNumber = uint32(n)
+		return err
+	})
+	parse("NATIVE_HISTOGRAM_MIN_RESET_DURATION", func(v string) error {
+		return c.NativeHistogram.MinResetDuration.UnmarshalText([]byte(v))
+	})
+	parse("NATIVE_HISTOGRAM_ZERO_THRESHOLD", func(v string) (err error) {
+		c.NativeHistogram.ZeroThreshold, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+	parse("NATIVE_HISTOGRAM_MAX_ZERO_THRESHOLD", func(v string) (err error) {
+		c.NativeHistogram.MaxZeroThreshold, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+
+	str("REQUEST_COUNTER_METRIC_NAME", &c.MetricNames.RequestCounter)
+	str("REQUEST_DURATION_METRIC_NAME", &c.MetricNames.RequestDuration)
+	str("REQUEST_SIZE_METRIC_NAME", &c.MetricNames.RequestSize)
+	str("RESPONSE_SIZE_METRIC_NAME", &c.MetricNames.ResponseSize)
+
+	return errors.Join(errs...)
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Validate reports all the invalid values of the configuration as a single
+// joined error.
+func (c Config) Validate() error {
+	var errs []error
+
+	if err := validatePath(c.Path); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateBuckets(c.Buckets); err != nil {
+		errs = append(errs, err)
+	}
+	for _, path := range c.Ignore {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("ignored path %q must start with a slash", path))
+		}
+	}
+
+	nh := c.NativeHistogram
+	if nh.BucketFactor != 0 {
+		if err := validateBucketFactor(nh.BucketFactor); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if nh.MinResetDuration < 0 {
+		errs = append(errs, fmt.Errorf("native histogram min reset duration must not be negative, got %v", time.Duration(nh.MinResetDuration)))
+	}
+	errs = append(errs, validateZeroThresholds(nh.ZeroThreshold, nh.MaxZeroThreshold)...)
+
+	for _, name := range []string{
+		c.MetricNames.RequestCounter,
+		c.MetricNames.RequestDuration,
+		c.MetricNames.RequestSize,
+		c.MetricNames.ResponseSize,
+	} {
+		if name == "" {
+			continue
+		}
+		if err := validateMetricName(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Namespace != nil && *c.Namespace != "" && !metricNameRe.MatchString(*c.Namespace) {
+		errs = append(errs, fmt.Errorf("invalid namespace %q", *c.Namespace))
+	}
+	if c.Subsystem != nil && *c.Subsystem != "" && !metricNameRe.MatchString(*c.Subsystem) {
+		errs = append(errs, fmt.Errorf("invalid subsystem %q", *c.Subsystem))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options validates the configuration and converts it into options to pass to
+// New, along with other options such as Engine.
+func (c Config) Options() ([]PrometheusOption, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var opts []PrometheusOption
+	if c.Path != "" {
+		opts = append(opts, Path(c.Path))
+	}
+	if c.Namespace != nil {
+		opts = append(opts, Namespace(*c.Namespace))
+	}
+	if c.Subsystem != nil {
+		opts = append(opts, Subsystem(*c.Subsystem))
+	}
+	if c.Token != "" {
+		opts = append(opts, Token(c.Token))
+	}
+	if len(c.Buckets) > 0 {
+		opts = append(opts, BucketSize(c.Buckets))
+	}
+	if len(c.Ignore) > 0 {
+		opts = append(opts, Ignore(c.Ignore...))
+	}
+
+	nh := c.NativeHistogram
+	if nh.Enabled {
+		opts = append(opts, NativeHistogram(true))
+	}
+	if nh.Hybrid {
+		opts = append(opts, HybridHistogram(true))
+	}
+	if nh.BucketFactor != 0 {
+		opts = append(opts, NativeHistogramBucketFactor(nh.BucketFactor))
+	}
+	if nh.MaxBucketNumber != 0 {
+		opts = append(opts, NativeHistogramMaxBucketNumber(nh.MaxBucketNumber))
+	}
+	if nh.MinResetDuration != 0 {
+		opts = append(opts, NativeHistogramMinResetDuration(time.Duration(nh.MinResetDuration)))
+	}
+	if nh.ZeroThreshold != 0 {
+		opts = append(opts, NativeHistogramZeroThreshold(nh.ZeroThreshold))
+	}
+	if nh.MaxZeroThreshold != 0 {
+		opts = append(opts, NativeHistogramMaxZeroThreshold(nh.MaxZeroThreshold))
+	}
+
+	mn := c.MetricNames
+	if mn.RequestCounter != "" {
+		opts = append(opts, RequestCounterMetricName(mn.RequestCounter))
+	}
+	if mn.RequestDuration != "" {
+		opts = append(opts, RequestDurationMetricName(mn.RequestDuration))
+	}
+	if mn.RequestSize != "" {
+		opts = append(opts, RequestSizeMetricName(mn.RequestSize))
+	}
+	if mn.ResponseSize != "" {
+		opts = append(opts, ResponseSizeMetricName(mn.ResponseSize))
+	}
+
+	return opts, nil
+}
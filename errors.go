@@ -0,0 +1,48 @@
+package ginprom
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultErrorsMetricName = "errors_total"
+
+// errorType returns the name of the type of an error attached to the gin
+// context.
+func errorType(err *gin.Error) string {
+	switch {
+	case err.IsType(gin.ErrorTypeBind):
+		return "bind"
+	case err.IsType(gin.ErrorTypeRender):
+		return "render"
+	case err.IsType(gin.ErrorTypePublic):
+		return "public"
+	case err.IsType(gin.ErrorTypePrivate):
+		return "private"
+	default:
+		return "other"
+	}
+}
+
+func (p *Prometheus) registerErrors() {
+	labels := []string{"method", "path", "type"}
+	if p.errorClassifier != nil {
+		labels = append(labels, "kind")
+	}
+	p.errCnt = prometheus.NewCounterVec(
+		p.counterOpts(defaultErrorsMetricName, "How many errors were attached to the HTTP requests by the handlers, partitioned by method, path and gin error type."),
+		labels,
+	)
+	p.mustRegister(p.errCnt)
+}
+
+// observeErrors counts the errors attached to the context with c.Error.
+func (p *Prometheus) observeErrors(c *gin.Context, path string) {
+	for _, err := range c.Errors {
+		labels := []string{c.Request.Method, path, errorType(err)}
+		if p.errorClassifier != nil {
+			labels = append(labels, p.errorClassifier(err.Err))
+		}
+		p.errCnt.WithLabelValues(labels...).Inc()
+	}
+}
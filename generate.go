@@ -0,0 +1,236 @@
+package ginprom
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultErrorRatioThreshold is the ratio of 5xx responses above which the
+// generated alert fires.
+var defaultErrorRatioThreshold = "0.05"
+
+// errorCodeMatcher selects the 5xx responses whatever the status code label
+// mode, the code label holding either the exact code or the class.
+var errorCodeMatcher = `code=~"5.*"`
+
+// redNames holds the fully-qualified names of the RED metrics described by a
+// configuration, falling back to the defaults of New.
+type redNames struct {
+	reqCnt string
+	reqDur string
+	// native is set when the request duration only has the native histogram
+	// representation, which has no _bucket series.
+	native bool
+}
+
+func (c Config) redNames() redNames {
+	ns, sys := defaultNs, defaultSys
+	if c.Namespace != nil {
+		ns = *c.Namespace
+	}
+	if c.Subsystem != nil {
+		sys = *c.Subsystem
+	}
+	reqCnt, reqDur := defaultReqCntMetricName, defaultReqDurMetricName
+	if c.MetricNames.RequestCounter != "" {
+		reqCnt = c.MetricNames.RequestCounter
+	}
+	if c.MetricNames.RequestDuration != "" {
+		reqDur = c.MetricNames.RequestDuration
+	}
+	return redNames{
+		reqCnt: prometheus.BuildFQName(ns, sys, reqCnt),
+		reqDur: prometheus.BuildFQName(ns, sys, reqDur),
+		native: c.NativeHistogram.Enabled && !c.NativeHistogram.Hybrid,
+	}
+}
+
+// quantile returns the PromQL expression of a request duration quantile,
+// aggregated by the given labels over the given range.
+func (n redNames) quantile(q, by, matchers, window string) string {
+	if n.native {
+		return fmt.Sprintf("histogram_quantile(%s, sum by (%s) (rate(%s%s[%s])))", q, by, n.reqDur, matchers, window)
+	}
+	return fmt.Sprintf("histogram_quantile(%s, sum by (%s, le) (rate(%s_bucket%s[%s])))", q, by, n.reqDur, matchers, window)
+}
+
+// GenerateRules returns the recording rules of the request rate, error ratio
+// and duration quantiles per method and path, along with an alert on the
+// error ratio, using the metric names of the configuration.
+func GenerateRules(c Config) (RuleFile, error) {
+	if err := c.Validate(); err != nil {
+		return RuleFile{}, err
+	}
+	n := c.redNames()
+	by := "method, path"
+	record := func(metric, op string) string {
+		return "method_path:" + metric + ":" + op
+	}
+
+	recording := RuleGroup{Name: "ginprom-red-recording", Rules: []Rule{
+		{
+			Record: record(n.reqCnt, "rate5m"),
+			Expr:   fmt.Sprintf("sum by (%s) (rate(%s[5m]))", by, n.reqCnt),
+		},
+		{
+			Record: record(n.reqCnt, "errors_rate5m"),
+			Expr:   fmt.Sprintf("sum by (%s) (rate(%s{%s}[5m]))", by, n.reqCnt, errorCodeMatcher),
+		},
+		{
+			Record: record(n.reqCnt, "error_ratio_rate5m"),
+			Expr:   fmt.Sprintf("%s / %s", record(n.reqCnt, "errors_rate5m"), record(n.reqCnt, "rate5m")),
+		},
+	}}
+	for _, q := range []struct{ name, value string }{{"p50", "0.5"}, {"p90", "0.9"}, {"p99", "0.99"}} {
+		recording.Rules = append(recording.Rules, Rule{
+			Record: record(n.reqDur, q.name+"_rate5m"),
+			Expr:   n.quantile(q.value, by, "", "5m"),
+		})
+	}
+
+	alerting := RuleGroup{Name: "ginprom-red-alerting", Rules: []Rule{{
+		Alert: "HighErrorRatio",
+		Expr:  fmt.Sprintf("%s > %s", record(n.reqCnt, "error_ratio_rate5m"), defaultErrorRatioThreshold),
+		For:   "10m",
+		Labels: map[string]string{
+			"severity": "warning",
+		},
+		Annotations: map[string]string{
+			"summary": "More than 5% of the {{ $labels.method }} {{ $labels.path }} requests fail with a 5xx status",
+		},
+	}}}
+
+	return RuleFile{Groups: []RuleGroup{recording, alerting}}, nil
+}
+
+// grafanaDashboard is the subset of the Grafana dashboard model used by the
+// generated dashboard.
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          map[string]string `json:"time"`
+	Refresh       string            `json:"refresh"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string         `json:"name"`
+	Label      string         `json:"label"`
+	Type       string         `json:"type"`
+	Query      any            `json:"query"`
+	Datasource *grafanaRef    `json:"datasource,omitempty"`
+	Refresh    int            `json:"refresh,omitempty"`
+	Multi      bool           `json:"multi,omitempty"`
+	IncludeAll bool           `json:"includeAll,omitempty"`
+	Current    map[string]any `json:"current,omitempty"`
+}
+
+type grafanaRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int             `json:"id"`
+	Title       string          `json:"title"`
+	Type        string          `json:"type"`
+	Datasource  grafanaRef      `json:"datasource"`
+	GridPos     map[string]int  `json:"gridPos"`
+	FieldConfig map[string]any  `json:"fieldConfig"`
+	Targets     []grafanaTarget `json:"targets"`
+}
+
+type grafanaTarget struct {
+	RefID        string     `json:"refId"`
+	Expr         string     `json:"expr"`
+	LegendFormat string     `json:"legendFormat"`
+	Datasource   grafanaRef `json:"datasource"`
+}
+
+// GenerateDashboard returns a Grafana dashboard, as JSON, showing the request
+// rate, error ratio and duration quantiles per path, using the metric names
+// of the configuration.
+func GenerateDashboard(c Config) ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	n := c.redNames()
+	ds := grafanaRef{Type: "prometheus", UID: "${datasource}"}
+	matchers := `{method=~"$method", path=~"$path"}`
+	errMatchers := `{method=~"$method", path=~"$path", ` + errorCodeMatcher + "}"
+
+	panel := func(id int, title, unit string, targets ...grafanaTarget) grafanaPanel {
+		for i := range targets {
+			targets[i].RefID = string(rune('A' + i))
+			targets[i].Datasource = ds
+		}
+		return grafanaPanel{
+			ID:         id,
+			Title:      title,
+			Type:       "timeseries",
+			Datasource: ds,
+			GridPos:    map[string]int{"h": 8, "w": 12, "x": 12 * ((id - 1) % 2), "y": 8 * ((id - 1) / 2)},
+			FieldConfig: map[string]any{
+				"defaults":  map[string]any{"unit": unit},
+				"overrides": []any{},
+			},
+			Targets: targets,
+		}
+	}
+	all := map[string]any{"text": "All", "value": "$__all"}
+
+	d := grafanaDashboard{
+		Title:         "Gin RED metrics",
+		UID:           "ginprom-red",
+		Tags:          []string{"ginprom", "gin"},
+		SchemaVersion: 39,
+		Time:          map[string]string{"from": "now-6h", "to": "now"},
+		Refresh:       "30s",
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name: "method", Label: "Method", Type: "query", Datasource: &ds,
+				Query:   fmt.Sprintf("label_values(%s, method)", n.reqCnt),
+				Refresh: 2, Multi: true, IncludeAll: true, Current: all,
+			},
+			{
+				Name: "path", Label: "Path", Type: "query", Datasource: &ds,
+				Query:   fmt.Sprintf("label_values(%s, path)", n.reqCnt),
+				Refresh: 2, Multi: true, IncludeAll: true, Current: all,
+			},
+		}},
+		Panels: []grafanaPanel{
+			panel(1, "Request rate", "reqps", grafanaTarget{
+				Expr:         fmt.Sprintf("sum by (path) (rate(%s%s[$__rate_interval]))", n.reqCnt, matchers),
+				LegendFormat: "{{path}}",
+			}),
+			panel(2, "Error ratio", "percentunit", grafanaTarget{
+				Expr: fmt.Sprintf(
+					"sum by (path) (rate(%s%s[$__rate_interval])) / sum by (path) (rate(%s%s[$__rate_interval]))",
+					n.reqCnt, errMatchers, n.reqCnt, matchers,
+				),
+				LegendFormat: "{{path}}",
+			}),
+			panel(3, "Request duration", "s",
+				grafanaTarget{Expr: n.quantile("0.5", "path", matchers, "$__rate_interval"), LegendFormat: "p50 {{path}}"},
+				grafanaTarget{Expr: n.quantile("0.9", "path", matchers, "$__rate_interval"), LegendFormat: "p90 {{path}}"},
+				grafanaTarget{Expr: n.quantile("0.99", "path", matchers, "$__rate_interval"), LegendFormat: "p99 {{path}}"},
+			),
+			panel(4, "Requests by status code", "reqps", grafanaTarget{
+				Expr:         fmt.Sprintf("sum by (code) (rate(%s%s[$__rate_interval]))", n.reqCnt, matchers),
+				LegendFormat: "{{code}}",
+			}),
+		},
+	}
+
+	return json.MarshalIndent(d, "", "  ")
+}
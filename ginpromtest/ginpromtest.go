@@ -0,0 +1,157 @@
+// Package ginpromtest provides helpers to perform requests against a gin
+// engine and assert on the metrics recorded by a ginprom instance.
+package ginpromtest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Depado/ginprom"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Request performs a request against the handler, usually a *gin.Engine, and
+// returns the recorded response.
+func Request(t testing.TB, h http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, body))
+	return w
+}
+
+// Gather returns the metric families gathered from the registry of the
+// instance. The test fails if they can't be gathered.
+func Gather(t testing.TB, p *ginprom.Prometheus) []*dto.MetricFamily {
+	t.Helper()
+	mfs, err := p.Gather()
+	if err != nil {
+		t.Fatalf("ginpromtest: unable to gather metrics: %v", err)
+	}
+	return mfs
+}
+
+// Family returns the metric family with the given fully-qualified name, or nil
+// if it wasn't found.
+func Family(mfs []*dto.MetricFamily, name string) *dto.MetricFamily {
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf
+		}
+	}
+	return nil
+}
+
+// Metrics returns the metrics of the family with the given fully-qualified
+// name having all the given label values.
+func Metrics(mfs []*dto.MetricFamily, name string, labels map[string]string) []*dto.Metric {
+	mf := Family(mfs, name)
+	if mf == nil {
+		return nil
+	}
+
+	var ms []*dto.Metric
+	for _, m := range mf.GetMetric() {
+		if matches(m, labels) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func matches(m *dto.Metric, labels map[string]string) bool {
+	for name, value := range labels {
+		found := false
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == name {
+				found = lp.GetValue() == value
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Counter returns the number of requests recorded by the request counter of
+// the instance for the given path and status code, summed over the other
+// labels. The code may be a status class such as "5xx", which matches in every
+// status code label mode, while an exact code doesn't match when only the
+// class is recorded.
+func Counter(t testing.TB, p *ginprom.Prometheus, path, code string) float64 {
+	t.Helper()
+	name := prometheus.BuildFQName(p.Namespace, p.Subsystem, p.RequestCounterMetricName)
+	var total float64
+	for _, m := range Metrics(Gather(t, p), name, map[string]string{"path": path}) {
+		if matchesCode(m, code) {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+// matchesCode reports whether the code or code_class label of the metric
+// matches the code, a class also matching the exact codes it contains.
+func matchesCode(m *dto.Metric, code string) bool {
+	class, isClass := strings.CutSuffix(code, "xx")
+	isClass = isClass && len(class) == 1
+	for _, lp := range m.GetLabel() {
+		switch lp.GetName() {
+		case "code":
+			if lp.GetValue() == code {
+				return true
+			}
+			if isClass && len(lp.GetValue()) == 3 && strings.HasPrefix(lp.GetValue(), class) {
+				if _, err := strconv.Atoi(lp.GetValue()); err == nil {
+					return true
+				}
+			}
+		case "code_class":
+			if isClass && lp.GetValue() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HistogramCount returns the number of observations recorded by the request
+// duration histogram of the instance for the given path, summed over the other
+// labels.
+func HistogramCount(t testing.TB, p *ginprom.Prometheus, path string) uint64 {
+	t.Helper()
+	name := prometheus.BuildFQName(p.Namespace, p.Subsystem, p.RequestDurationMetricName)
+	var total uint64
+	for _, m := range Metrics(Gather(t, p), name, map[string]string{"path": path}) {
+		total += m.GetHistogram().GetSampleCount()
+	}
+	return total
+}
+
+// AssertCounter checks that the request counter of the instance recorded want
+// requests for the given path and status code.
+func AssertCounter(t testing.TB, p *ginprom.Prometheus, path, code string, want float64) bool {
+	t.Helper()
+	if got := Counter(t, p, path, code); got != want {
+		t.Errorf("ginpromtest: requests counter for path %q and code %q = %v, want %v", path, code, got, want)
+		return false
+	}
+	return true
+}
+
+// AssertHistogramCount checks that the request duration histogram of the
+// instance recorded want observations for the given path.
+func AssertHistogramCount(t testing.TB, p *ginprom.Prometheus, path string, want uint64) bool {
+	t.Helper()
+	if got := HistogramCount(t, p, path); got != want {
+		t.Errorf("ginpromtest: request duration count for path %q = %v, want %v", path, got, want)
+		return false
+	}
+	return true
+}
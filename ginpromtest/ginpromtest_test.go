@@ -0,0 +1,90 @@
+package ginpromtest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Depado/ginprom"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func setup() (*gin.Engine, *ginprom.Prometheus) {
+	r := gin.New()
+	p := ginprom.New(ginprom.Engine(r), ginprom.Registry(prometheus.NewRegistry()))
+	r.Use(p.Instrument())
+	r.GET("/user/:id", func(c *gin.Context) {
+		if c.Param("id") == "0" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	return r, p
+}
+
+func TestAssertions(t *testing.T) {
+	r, p := setup()
+
+	assert.Equal(t, http.StatusOK, Request(t, r, http.MethodGet, "/user/1", nil).Code)
+	assert.Equal(t, http.StatusOK, Request(t, r, http.MethodGet, "/user/2", nil).Code)
+	assert.Equal(t, http.StatusNotFound, Request(t, r, http.MethodGet, "/user/0", nil).Code)
+
+	assert.True(t, AssertCounter(t, p, "/user/:id", "200", 2))
+	assert.True(t, AssertCounter(t, p, "/user/:id", "404", 1))
+	assert.True(t, AssertCounter(t, p, "/user/:id", "500", 0))
+	assert.True(t, AssertHistogramCount(t, p, "/user/:id", 3))
+	assert.True(t, AssertHistogramCount(t, p, "/unknown", 0))
+}
+
+// fakeTB records the failures instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failures int
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.failures++
+}
+
+func TestAssertionsFailure(t *testing.T) {
+	r, p := setup()
+	Request(t, r, http.MethodGet, "/user/1", nil)
+
+	ft := &fakeTB{TB: t}
+	assert.False(t, AssertCounter(ft, p, "/user/:id", "200", 2))
+	assert.False(t, AssertHistogramCount(ft, p, "/user/:id", 2))
+	assert.Equal(t, 2, ft.failures)
+}
+
+func TestCounterStatusClass(t *testing.T) {
+	for _, mode := range []ginprom.StatusCodeLabel{ginprom.StatusCodeExact, ginprom.StatusCodeClass, ginprom.StatusCodeExactAndClass} {
+		r := gin.New()
+		p := ginprom.New(ginprom.Engine(r), ginprom.Registry(prometheus.NewRegistry()), ginprom.StatusCode(mode))
+		r.Use(p.Instrument())
+		r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusServiceUnavailable) })
+		Request(t, r, http.MethodGet, "/fail", nil)
+
+		assert.True(t, AssertCounter(t, p, "/fail", "5xx", 1), "mode %d", mode)
+		assert.True(t, AssertCounter(t, p, "/fail", "4xx", 0), "mode %d", mode)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	r, p := setup()
+	Request(t, r, http.MethodGet, "/user/1", nil)
+
+	mfs := Gather(t, p)
+	name := prometheus.BuildFQName(p.Namespace, p.Subsystem, p.RequestCounterMetricName)
+	assert.NotNil(t, Family(mfs, name))
+	assert.Nil(t, Family(mfs, "unknown"))
+	assert.Len(t, Metrics(mfs, name, map[string]string{"method": "GET"}), 1)
+	assert.Len(t, Metrics(mfs, name, map[string]string{"method": "POST"}), 0)
+}
@@ -0,0 +1,71 @@
+package ginprom
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// middlewareFrame is a middleware being timed, children accumulates the time
+// spent in the timed middlewares it called.
+type middlewareFrame struct {
+	start    time.Time
+	children time.Duration
+}
+
+// middlewareStack holds the timed middlewares of a request, from the
+// outermost one to the one running.
+type middlewareStack struct {
+	frames []*middlewareFrame
+}
+
+func (p *Prometheus) registerMiddlewareDuration() {
+	p.mwDurOnce.Do(func() {
+		p.mwDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultMiddlewareDurMetricName, "The time spent in the timed middlewares, excluding the timed middlewares they called, partitioned by middleware and path.", p.BucketsSize),
+			[]string{"middleware", "path"},
+		)
+		p.mustRegister(p.mwDur)
+	})
+}
+
+// TimeMiddleware wraps a middleware or handler to record the time spent in
+// it, labeled by the given name and the request path. The time spent in the
+// middlewares and handlers it calls with c.Next() and that are wrapped as well
+// is excluded, so wrap every handler of the chain to see where the request
+// time goes.
+// Example:
+// r.Use(p.Instrument(), p.TimeMiddleware("auth", auth))
+// r.GET("/user/:id", p.TimeMiddleware("user", getUser))
+func (p *Prometheus) TimeMiddleware(name string, h gin.HandlerFunc) gin.HandlerFunc {
+	p.registerMiddlewareDuration()
+	return func(c *gin.Context) {
+		path := p.RequestPathFunc(c)
+		if path == "" || p.isIgnored(path) {
+			h(c)
+			return
+		}
+
+		var stack *middlewareStack
+		if v, ok := c.Get(middlewareStackKey); ok {
+			stack = v.(*middlewareStack)
+		} else {
+			stack = &middlewareStack{}
+			c.Set(middlewareStackKey, stack)
+		}
+
+		frame := &middlewareFrame{start: time.Now()}
+		stack.frames = append(stack.frames, frame)
+		defer func() {
+			total := time.Since(frame.start)
+			stack.frames = stack.frames[:len(stack.frames)-1]
+			if n := len(stack.frames); n > 0 {
+				stack.frames[n-1].children += total
+			}
+			p.mwDur.WithLabelValues(name, path).Observe((total - frame.children).Seconds())
+		}()
+
+		h(c)
+	}
+}
@@ -1,10 +1,12 @@
 package ginprom
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -111,6 +113,142 @@ func Registry(r *prometheus.Registry) PrometheusOption {
 	}
 }
 
+// Registerer is an option allowing to set the prometheus.Registerer the
+// metrics are registered with, taking precedence over Registry. Use this
+// option to register through a wrapping registerer, for example one created
+// with prometheus.WrapRegistererWithPrefix.
+// Example:
+// reg := prometheus.NewRegistry()
+// p := ginprom.New(Registerer(prometheus.WrapRegistererWithPrefix("app_", reg)), Gatherer(reg))
+func Registerer(r prometheus.Registerer) PrometheusOption {
+	return func(p *Prometheus) {
+		p.Registerer = r
+	}
+}
+
+// Gatherer is an option allowing to set the prometheus.Gatherer the metrics
+// endpoint gathers from, taking precedence over Registry. Use this option to
+// expose metrics merged from several sources with prometheus.Gatherers.
+// Example:
+// p := ginprom.New(Gatherer(prometheus.Gatherers{reg, prometheus.DefaultGatherer}))
+func Gatherer(g prometheus.Gatherer) PrometheusOption {
+	return func(p *Prometheus) {
+		p.Gatherer = g
+	}
+}
+
+// ConstLabels is an option allowing to add constant labels to all the metrics
+// of the instance, custom ones included.
+func ConstLabels(labels prometheus.Labels) PrometheusOption {
+	return func(p *Prometheus) {
+		if p.ConstLabels == nil {
+			p.ConstLabels = prometheus.Labels{}
+		}
+		for k, v := range labels {
+			p.ConstLabels[k] = v
+		}
+	}
+}
+
+// Instance is an option allowing to distinguish the metrics of this instance
+// from the ones of other instances sharing the same registry, using the
+// "gin_instance" constant label. This allows to run multiple engines (e.g. an
+// admin and a public one) in the same process without registration conflicts.
+// Example:
+// admin := ginprom.New(Instance("admin"))
+// public := ginprom.New(Instance("public"))
+func Instance(name string) PrometheusOption {
+	return ConstLabels(prometheus.Labels{defaultInstanceLabel: name})
+}
+
+// IsolatedRegistry is an option allowing to create a new registry dedicated
+// to this instance, when no Registry is set. Use Gatherers or ExposeWith to
+// expose the metrics of multiple instances together.
+func IsolatedRegistry() PrometheusOption {
+	return func(p *Prometheus) {
+		p.isolatedRegistry = true
+	}
+}
+
+// ExposeWith is an option allowing to expose the metrics of other instances
+// on the metrics endpoint of this instance. The instances must be
+// distinguished, either using Instance or different namespaces or subsystems.
+// Example:
+// public := ginprom.New(IsolatedRegistry(), Instance("public"))
+// admin := ginprom.New(Engine(r), IsolatedRegistry(), Instance("admin"), ExposeWith(public))
+func ExposeWith(instances ...*Prometheus) PrometheusOption {
+	return func(p *Prometheus) {
+		p.exposed = append(p.exposed, instances...)
+	}
+}
+
+// RuntimeMetrics is an option allowing to register the Go runtime and process
+// collectors, along with a build info metric exposing the main module version
+// and VCS revision. This is mostly useful with a custom Registry, which lacks
+// the collectors registered by default in the global one. The rules select
+// additional runtime/metrics to expose, see collectors.MetricsAll and others.
+// They can't be used with the default registry, nor with a registry already
+// having a Go collector exposing other metrics, which panics.
+// Example:
+// p := ginprom.New(Registry(reg), RuntimeMetrics(collectors.MetricsGC, collectors.MetricsScheduler))
+func RuntimeMetrics(rules ...collectors.GoRuntimeMetricsRule) PrometheusOption {
+	return func(p *Prometheus) {
+		p.runtimeMetrics = true
+		p.runtimeMetricsRules = rules
+	}
+}
+
+// EngineMetrics is an option allowing to expose the routes registered on the
+// gin engine, read at scrape time: a route_info metric labeled by method, path
+// and handler, the number of routes per method, and the length of the
+// handlers chain of each route once it has been served.
+func EngineMetrics(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.engineMetrics = enabled
+	}
+}
+
+// DBStats is an option allowing to expose the connection pool statistics of a
+// database, read from DB.Stats at scrape time and labeled by the given name.
+// It can be used multiple times to expose several databases.
+// Example:
+// p := ginprom.New(DBStats("users", usersDB), DBStats("orders", ordersDB))
+func DBStats(name string, db *sql.DB) PrometheusOption {
+	return func(p *Prometheus) {
+		if p.dbs == nil {
+			p.dbs = make(map[string]*sql.DB)
+		}
+		p.dbs[name] = db
+	}
+}
+
+// PreInitialize is an option allowing to create the zero-valued request
+// counter and duration series of every route registered on the engine, for
+// the given status codes (200 if none is given), so rate() and absent() work
+// right after a deploy. The routes are walked on the first request or scrape,
+// or when calling InitializeRoutes. Ignored routes are skipped. The hosts must
+// be set with PreInitializeHosts, and the default HandlerNameFunc and
+// RequestPathFunc used without CustomCounterLabels.
+// Example:
+// p := ginprom.New(Engine(r), PreInitialize(200, 404, 500), PreInitializeHosts("api.example.com"))
+func PreInitialize(codes ...int) PrometheusOption {
+	return func(p *Prometheus) {
+		p.preInitCodes = append([]int{}, codes...)
+	}
+}
+
+// PreInitializeHosts is an option allowing to set the host label values of the
+// series created by PreInitialize, which is required along with it. They must
+// be the values returned by HostFunc, that is the Host header of the requests
+// (including the port, if any) with the default HostFunc.
+// Example:
+// p := ginprom.New(Engine(r), PreInitialize(200), PreInitializeHosts("api.example.com"))
+func PreInitializeHosts(hosts ...string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.preInitHosts = hosts
+	}
+}
+
 // HandlerNameFunc is an option allowing to set the HandlerNameFunc with New.
 // Use this option if you want to override the default behavior (i.e. using
 // (*gin.Context).HandlerName). This is useful when wanting to group different
@@ -167,6 +305,16 @@ func RequestPathFunc(f func(c *gin.Context) string) PrometheusOption {
 	}
 }
 
+// PanicCode is an option allowing to set the value of the code label used
+// when a handler panics. Defaults to "500".
+// Example:
+// p := ginprom.New(PanicCode("panic"))
+func PanicCode(code string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.PanicCode = code
+	}
+}
+
 func CustomCounterLabels(labels []string, f func(c *gin.Context) map[string]string) PrometheusOption {
 	return func(p *Prometheus) {
 		p.customCounterLabelsProvider = f
@@ -180,6 +328,203 @@ func NativeHistogram(nh bool) PrometheusOption {
 	}
 }
 
+// Objectives is an option allowing to declare service level objectives, for
+// which the good and total requests are counted per objective and SLI. Use
+// SLORules to generate the matching recording and alerting rules.
+// Example:
+// p := ginprom.New(Objectives(ginprom.Objective{Name: "api", Route: "/api/*", Target: 0.99, Latency: 300 * time.Millisecond}))
+func Objectives(objectives ...Objective) PrometheusOption {
+	return func(p *Prometheus) {
+		p.objectives = append(p.objectives, objectives...)
+	}
+}
+
+// CancelCode is an option allowing to detect the requests cancelled by the
+// client while the handlers were running, which are then recorded with the
+// given code instead of the response status, and counted in a dedicated
+// cancelled requests counter. Detection is disabled when the code is empty,
+// which is the default.
+// Example:
+// p := ginprom.New(CancelCode("499"))
+func CancelCode(code string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.CancelCode = code
+	}
+}
+
+// ErrorMetrics is an option allowing to count the errors attached to the
+// requests by the handlers with c.Error, labeled by method, path and gin error
+// type (bind, render, public, private or other), so failures are visible even
+// when the response status is a successful one.
+func ErrorMetrics(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.errorMetrics = enabled
+	}
+}
+
+// ErrorClassifier is an option allowing to add a kind label to the errors
+// counter, holding the value returned by the given function for each error.
+// It enables ErrorMetrics.
+// Example:
+//
+//	p := ginprom.New(ErrorClassifier(func(err error) string {
+//		if errors.Is(err, sql.ErrNoRows) {
+//			return "not_found"
+//		}
+//		return "internal"
+//	}))
+func ErrorClassifier(f func(err error) string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.errorClassifier = f
+	}
+}
+
+// PhaseMetrics is an option allowing to record the phases of the requests
+// timed with StartTimer, such as database or downstream HTTP calls, in a
+// phase duration histogram labeled by path and phase once the request is done.
+func PhaseMetrics(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.phaseMetrics = enabled
+	}
+}
+
+// StatusCode is an option allowing to record the status class (e.g. "4xx")
+// instead of the exact status code in the code label of the request counter,
+// or in an additional code_class label, to reduce the number of series.
+// Example:
+// p := ginprom.New(StatusCode(StatusCodeClass))
+func StatusCode(mode StatusCodeLabel) PrometheusOption {
+	return func(p *Prometheus) {
+		p.statusCodeLabel = mode
+	}
+}
+
+// StatusCodeFunc is an option allowing to map the status codes to the values
+// of the code label of the request counter, for example to group rarely used
+// codes. It takes precedence over the StatusCodeClass mode, the code_class
+// label of the StatusCodeExactAndClass mode still being the status class.
+// Example:
+//
+//	p := ginprom.New(StatusCodeFunc(func(code int) string {
+//		if code == 499 {
+//			return "client_cancel"
+//		}
+//		return strconv.Itoa(code/100) + "xx"
+//	}))
+func StatusCodeFunc(f func(code int) string) PrometheusOption {
+	return func(p *Prometheus) {
+		p.statusCodeFunc = f
+	}
+}
+
+// Apdex is an option allowing to classify the requests of every route as
+// satisfied (served within t), tolerating (within 4t) or frustrated (slower,
+// or failing with a 5xx status), so the Apdex score can be computed in PromQL
+// regardless of the request duration buckets.
+// Example:
+// p := ginprom.New(Apdex(500 * time.Millisecond))
+func Apdex(t time.Duration) PrometheusOption {
+	return func(p *Prometheus) {
+		p.apdex = t
+	}
+}
+
+// ApdexRoute is an option allowing to set the Apdex threshold of a single
+// route, overriding the one set with Apdex. The path is the one of the path
+// label, that is the route pattern by default.
+// Example:
+// p := ginprom.New(Apdex(500 * time.Millisecond), ApdexRoute("/search", 2 * time.Second))
+func ApdexRoute(path string, t time.Duration) PrometheusOption {
+	return func(p *Prometheus) {
+		if p.apdexRoutes == nil {
+			p.apdexRoutes = make(map[string]time.Duration)
+		}
+		p.apdexRoutes[path] = t
+	}
+}
+
+// HybridHistogram is an option allowing to expose both the classic buckets
+// and the native histogram representation on every histogram, so scrapers
+// without native histogram support and dashboards relying on the buckets keep
+// working. It takes precedence over NativeHistogram, the classic buckets still
+// being set with BucketSize and the native ones with the NativeHistogram*
+// options.
+func HybridHistogram(hh bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.hybridHistogram = hh
+	}
+}
+
+// TimeToFirstByte is an option allowing to record the time elapsed until the
+// first WriteHeader or Write of the response, in a separate histogram sharing
+// the labels of the request duration histogram. This is mostly useful for
+// streaming endpoints for which the request duration is meaningless.
+func TimeToFirstByte(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.timeToFirstByte = enabled
+	}
+}
+
+// StreamMetrics is an option allowing to detect long-lived connections, that
+// is streamed responses (flushed repeatedly or using SSE) and hijacked
+// connections such as WebSocket upgrades. Those are reported through the
+// active connections, connection duration and streamed messages and bytes
+// metrics, and are excluded from the request duration histogram unless
+// StreamLatency is used.
+func StreamMetrics(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.streamMetrics = enabled
+	}
+}
+
+// StreamLatency is an option allowing to keep recording the long-lived
+// connections detected by StreamMetrics in the request duration histogram.
+func StreamLatency(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.streamLatency = enabled
+	}
+}
+
+// MeasureRequestBody is an option allowing to measure the request body by
+// counting the bytes actually read by the handlers, instead of trusting the
+// Content-Length header which is unknown for chunked uploads. Both the size
+// on the wire and the size decoded according to the Content-Encoding (gzip
+// and deflate) are reported. The request size then also accounts for the
+// exact size of the request line and headers.
+// Compressed bodies are decoded a second time, on a separate goroutine fed by
+// the reads of the handlers, which adds some latency to those reads. Decoding
+// stops after MaxDecodedBodySize bytes, 10MiB by default, the decoded size is
+// then not reported.
+func MeasureRequestBody(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.measureRequestBody = enabled
+	}
+}
+
+// MaxDecodedBodySize is an option allowing to set the number of bytes decoded
+// at most to measure the decoded size of compressed request bodies with
+// MeasureRequestBody, which bounds the work done for each request. Bodies are
+// not decoded at all when set to 0.
+// Example:
+// p := ginprom.New(Engine(r), MeasureRequestBody(true), MaxDecodedBodySize(1<<20))
+func MaxDecodedBodySize(size int64) PrometheusOption {
+	return func(p *Prometheus) {
+		p.maxDecodedBodySize = size
+	}
+}
+
+// ResponseEncodingMetrics is an option allowing to count the response bytes
+// sent on the wire and the ones written before compression, labeled by
+// Content-Encoding, to measure how effective compression is per route. When
+// the Instrument middleware is registered before the compression middleware,
+// the UncompressedSize middleware must be registered after it. When it is
+// registered after, the WireSize middleware must be registered before it.
+func ResponseEncodingMetrics(enabled bool) PrometheusOption {
+	return func(p *Prometheus) {
+		p.responseEncoding = enabled
+	}
+}
+
 func NativeHistogramBucketFactor(nhbf float64) PrometheusOption {
 	return func(p *Prometheus) {
 		p.NativeHistogramBucketFactor = nhbf
@@ -197,3 +542,24 @@ func NativeHistogramMinResetDuration(nhmrd time.Duration) PrometheusOption {
 		p.NativeHistogramMinResetDuration = nhmrd
 	}
 }
+
+// NativeHistogramZeroThreshold is an option allowing to set the width of the
+// zero bucket of native histograms, observations whose absolute value is
+// lower than or equal to it being counted in that bucket. Zero keeps
+// prometheus.DefNativeHistogramZeroThreshold, use
+// prometheus.NativeHistogramZeroThresholdZero for an actual zero threshold.
+func NativeHistogramZeroThreshold(nhzt float64) PrometheusOption {
+	return func(p *Prometheus) {
+		p.NativeHistogramZeroThreshold = nhzt
+	}
+}
+
+// NativeHistogramMaxZeroThreshold is an option allowing to widen the zero
+// bucket of native histograms up to the given threshold once the max bucket
+// number is reached, merging the closest buckets into it, before resorting to
+// a reset after the min reset duration.
+func NativeHistogramMaxZeroThreshold(nhmzt float64) PrometheusOption {
+	return func(p *Prometheus) {
+		p.NativeHistogramMaxZeroThreshold = nhmzt
+	}
+}
@@ -0,0 +1,56 @@
+package ginprom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// phases accumulates the time spent in the named phases of a request. It is
+// safe for concurrent use since handlers may time phases in goroutines.
+type phases struct {
+	sync.Mutex
+	durations map[string]time.Duration
+	// done is set once the phases are recorded, later ones being dropped.
+	done bool
+}
+
+func (ph *phases) add(name string, d time.Duration) {
+	ph.Lock()
+	defer ph.Unlock()
+	if !ph.done {
+		ph.durations[name] += d
+	}
+}
+
+// StartTimer starts timing the given phase of the request, such as "db", and
+// returns the function stopping it. The time spent in a phase timed multiple
+// times during a request is summed up, and recorded once the request is done.
+// It does nothing unless the PhaseMetrics option is used and the request is
+// instrumented.
+// Example:
+// stop := ginprom.StartTimer(c, "db")
+// rows, err := db.QueryContext(c, query)
+// stop()
+func StartTimer(c *gin.Context, name string) func() {
+	v, ok := c.Get(phasesKey)
+	if !ok {
+		return func() {}
+	}
+	ph := v.(*phases)
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() { ph.add(name, time.Since(start)) })
+	}
+}
+
+func (p *Prometheus) observePhases(ph *phases, path string) {
+	ph.Lock()
+	defer ph.Unlock()
+	ph.done = true
+	for name, d := range ph.durations {
+		p.phaseDur.WithLabelValues(path, name).Observe(d.Seconds())
+	}
+}
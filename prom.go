@@ -4,16 +4,21 @@
 package ginprom
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var defaultPath = "/metrics"
@@ -27,6 +32,39 @@ var defaultReqCntMetricName = "requests_total"
 var defaultReqDurMetricName = "request_duration"
 var defaultReqSzMetricName = "request_size_bytes"
 var defaultResSzMetricName = "response_size_bytes"
+var defaultPanicCntMetricName = "panics_total"
+var defaultPanicCode = "500"
+var defaultTTFBMetricName = "time_to_first_byte"
+var defaultActiveConnsMetricName = "active_connections"
+var defaultConnDurMetricName = "connection_duration"
+var defaultStreamMsgsMetricName = "streamed_messages_total"
+var defaultStreamBytesMetricName = "streamed_bytes_total"
+var defaultReqBodyWireMetricName = "request_body_wire_size_bytes"
+var defaultReqBodyDecodedMetricName = "request_body_decoded_size_bytes"
+var defaultResWireMetricName = "response_wire_bytes_total"
+var defaultResUncompressedMetricName = "response_uncompressed_bytes_total"
+var defaultCancelledMetricName = "cancelled_requests_total"
+var defaultMiddlewareDurMetricName = "middleware_duration"
+var defaultPhaseDurMetricName = "phase_duration"
+var defaultInstanceLabel = "gin_instance"
+var defaultConnDurBuckets = prometheus.ExponentialBuckets(0.1, 4, 10)
+var defaultMaxDecodedBodySize int64 = 10 << 20
+
+type contextKey int
+
+// Keys of the values set on the gin context.
+const (
+	// responseWriterKey holds the response writer wrapped by the Instrument
+	// middleware.
+	responseWriterKey contextKey = iota
+	// middlewareStackKey holds the stack of the middlewares timed with
+	// TimeMiddleware.
+	middlewareStackKey
+	// phasesKey holds the phases timed with StartTimer.
+	phasesKey
+	// wireSizeKey holds the response labels passed to WireSize.
+	wireSizeKey
+)
 
 // ErrInvalidToken is returned when the provided token is invalid or missing.
 var ErrInvalidToken = errors.New("invalid or missing token")
@@ -42,6 +80,11 @@ type pmapb struct {
 	values map[string]bool
 }
 
+type pmapi struct {
+	sync.RWMutex
+	values map[string]int
+}
+
 type pmapGauge struct {
 	sync.RWMutex
 	values map[string]prometheus.GaugeVec
@@ -62,6 +105,26 @@ type Prometheus struct {
 	reqCnt       *prometheus.CounterVec
 	reqDur       *prometheus.HistogramVec
 	reqSz, resSz prometheus.Summary
+	reqBodyWire  prometheus.Summary
+	reqBodyDec   prometheus.Summary
+	resWire      *prometheus.CounterVec
+	resUncomp    *prometheus.CounterVec
+	panicCnt     *prometheus.CounterVec
+	ttfbDur      *prometheus.HistogramVec
+
+	activeConns *prometheus.GaugeVec
+	connDur     *prometheus.HistogramVec
+	streamMsgs  *prometheus.CounterVec
+	streamBytes *prometheus.CounterVec
+	sloTotal    *prometheus.CounterVec
+	sloGood     *prometheus.CounterVec
+	apdexCnt    *prometheus.CounterVec
+	cancelCnt   *prometheus.CounterVec
+	errCnt      *prometheus.CounterVec
+	mwDur       *prometheus.HistogramVec
+	mwDurOnce   sync.Once
+	phaseDur    *prometheus.HistogramVec
+	client      clientMetrics
 
 	customGauges                pmapGauge
 	customCounters              pmapCounter
@@ -69,6 +132,31 @@ type Prometheus struct {
 	customCounterLabels         []string
 	customHistograms            pmapHistogram
 	nativeHistogram             bool
+	hybridHistogram             bool
+	statusCodeLabel             StatusCodeLabel
+	statusCodeFunc              func(code int) string
+	errorMetrics                bool
+	errorClassifier             func(err error) string
+	phaseMetrics                bool
+	dbs                         map[string]*sql.DB
+	objectives                  []Objective
+	apdex                       time.Duration
+	apdexRoutes                 map[string]time.Duration
+	timeToFirstByte             bool
+	streamMetrics               bool
+	streamLatency               bool
+	measureRequestBody          bool
+	maxDecodedBodySize          int64
+	responseEncoding            bool
+	isolatedRegistry            bool
+	runtimeMetrics              bool
+	runtimeMetricsRules         []collectors.GoRuntimeMetricsRule
+	engineMetrics               bool
+	routeChains                 pmapi
+	preInitCodes                []int
+	preInitHosts                []string
+	preInitOnce                 sync.Once
+	exposed                     []*Prometheus
 
 	MetricsPath     string
 	Namespace       string
@@ -78,14 +166,21 @@ type Prometheus struct {
 	Engine          *gin.Engine
 	BucketsSize     []float64
 	Registry        *prometheus.Registry
+	Registerer      prometheus.Registerer
+	Gatherer        prometheus.Gatherer
+	ConstLabels     prometheus.Labels
 	HandlerNameFunc func(c *gin.Context) string
 	RequestPathFunc func(c *gin.Context) string
 	HostFunc        func(c *gin.Context) string
 	HandlerOpts     promhttp.HandlerOpts
+	PanicCode       string
+	CancelCode      string
 
 	NativeHistogramBucketFactor     float64
 	NativeHistogramMaxBucketNumber  uint32
 	NativeHistogramMinResetDuration time.Duration
+	NativeHistogramZeroThreshold    float64
+	NativeHistogramMaxZeroThreshold float64
 
 	RequestCounterMetricName  string
 	RequestDurationMetricName string
@@ -163,13 +258,7 @@ func (p *Prometheus) AddCustomGauge(name, help string, labels []string) {
 	p.customGauges.Lock()
 	defer p.customGauges.Unlock()
 
-	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: p.Namespace,
-		Subsystem: p.Subsystem,
-		Name:      name,
-		Help:      help,
-	},
-		labels)
+	g := prometheus.NewGaugeVec(p.gaugeOpts(name, help), labels)
 	p.customGauges.values[name] = *g
 	p.mustRegister(g)
 }
@@ -204,12 +293,7 @@ func (p *Prometheus) AddCounterValue(name string, labelValues []string, value fl
 func (p *Prometheus) AddCustomCounter(name, help string, labels []string) {
 	p.customCounters.Lock()
 	defer p.customCounters.Unlock()
-	g := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: p.Namespace,
-		Subsystem: p.Subsystem,
-		Name:      name,
-		Help:      help,
-	}, labels)
+	g := prometheus.NewCounterVec(p.counterOpts(name, help), labels)
 	p.customCounters.values[name] = *g
 	p.mustRegister(g)
 }
@@ -227,37 +311,110 @@ func (p *Prometheus) AddCustomHistogramValue(name string, labelValues []string,
 	return nil
 }
 
-// AddCustomCounter adds a custom counter and registers it.
+// AddCustomHistogram adds a custom histogram and registers it.
 func (p *Prometheus) AddCustomHistogram(name, help string, labels []string) {
+	p.AddCustomHistogramWithSettings(name, help, labels, HistogramSettings{})
+}
+
+// HistogramSettings overrides the histogram settings of the instance for a
+// single custom histogram. Zero values keep the settings of the instance, and
+// whether the histogram is classic, native or hybrid depends on the instance.
+type HistogramSettings struct {
+	Buckets                         []float64
+	NativeHistogramBucketFactor     float64
+	NativeHistogramMaxBucketNumber  uint32
+	NativeHistogramMinResetDuration time.Duration
+	NativeHistogramZeroThreshold    float64
+	NativeHistogramMaxZeroThreshold float64
+}
+
+// AddCustomHistogramWithSettings adds a custom histogram using the given
+// settings and registers it.
+func (p *Prometheus) AddCustomHistogramWithSettings(name, help string, labels []string, s HistogramSettings) {
 	p.customHistograms.Lock()
 	defer p.customHistograms.Unlock()
 
-	var reqDurOpts prometheus.HistogramOpts
-	if p.nativeHistogram {
-		reqDurOpts = prometheus.HistogramOpts{
-			Namespace:                       p.Namespace,
-			Subsystem:                       p.Subsystem,
-			NativeHistogramBucketFactor:     p.NativeHistogramBucketFactor,
-			NativeHistogramMaxBucketNumber:  p.NativeHistogramMaxBucketNumber,
-			NativeHistogramMinResetDuration: p.NativeHistogramMinResetDuration,
-			Name:                            name,
-			Help:                            help,
+	opts := p.histogramOpts(name, help, s.Buckets)
+	if opts.NativeHistogramBucketFactor > 1 {
+		if s.NativeHistogramBucketFactor != 0 {
+			opts.NativeHistogramBucketFactor = s.NativeHistogramBucketFactor
 		}
-
-	} else {
-		reqDurOpts = prometheus.HistogramOpts{
-			Namespace: p.Namespace,
-			Subsystem: p.Subsystem,
-			Name:      name,
-			Help:      help,
+		if s.NativeHistogramMaxBucketNumber != 0 {
+			opts.NativeHistogramMaxBucketNumber = s.NativeHistogramMaxBucketNumber
+		}
+		if s.NativeHistogramMinResetDuration != 0 {
+			opts.NativeHistogramMinResetDuration = s.NativeHistogramMinResetDuration
+		}
+		if s.NativeHistogramZeroThreshold != 0 {
+			opts.NativeHistogramZeroThreshold = s.NativeHistogramZeroThreshold
+		}
+		if s.NativeHistogramMaxZeroThreshold != 0 {
+			opts.NativeHistogramMaxZeroThreshold = s.NativeHistogramMaxZeroThreshold
 		}
 	}
 
-	g := prometheus.NewHistogramVec(reqDurOpts, labels)
+	g := prometheus.NewHistogramVec(opts, labels)
 	p.customHistograms.values[name] = *g
 	p.mustRegister(g)
 }
 
+// histogramOpts returns the options of an histogram, using either the classic
+// buckets or the native histogram parameters of the instance, or both in
+// hybrid mode. Classic histograms without buckets use prometheus.DefBuckets.
+func (p *Prometheus) histogramOpts(name, help string, buckets []float64) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Namespace:   p.Namespace,
+		Subsystem:   p.Subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: p.ConstLabels,
+	}
+	if p.nativeHistogram || p.hybridHistogram {
+		opts.NativeHistogramBucketFactor = p.NativeHistogramBucketFactor
+		opts.NativeHistogramMaxBucketNumber = p.NativeHistogramMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = p.NativeHistogramMinResetDuration
+		opts.NativeHistogramZeroThreshold = p.NativeHistogramZeroThreshold
+		opts.NativeHistogramMaxZeroThreshold = p.NativeHistogramMaxZeroThreshold
+	}
+	if !p.nativeHistogram || p.hybridHistogram {
+		opts.Buckets = buckets
+		if len(buckets) == 0 {
+			opts.Buckets = prometheus.DefBuckets
+		}
+	}
+	return opts
+}
+
+func (p *Prometheus) counterOpts(name, help string) prometheus.CounterOpts {
+	return prometheus.CounterOpts{
+		Namespace:   p.Namespace,
+		Subsystem:   p.Subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: p.ConstLabels,
+	}
+}
+
+func (p *Prometheus) gaugeOpts(name, help string) prometheus.GaugeOpts {
+	return prometheus.GaugeOpts{
+		Namespace:   p.Namespace,
+		Subsystem:   p.Subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: p.ConstLabels,
+	}
+}
+
+func (p *Prometheus) summaryOpts(name, help string) prometheus.SummaryOpts {
+	return prometheus.SummaryOpts{
+		Namespace:   p.Namespace,
+		Subsystem:   p.Subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: p.ConstLabels,
+	}
+}
+
 func (p *Prometheus) mustRegister(c ...prometheus.Collector) {
 	registerer, _ := p.getRegistererAndGatherer()
 	registerer.MustRegister(c...)
@@ -267,7 +424,18 @@ func (p *Prometheus) mustRegister(c ...prometheus.Collector) {
 // If no options are passed, sane defaults are used.
 // If a router is passed using the Engine() option, this instance will
 // automatically bind to it.
+// New panics if the options are invalid, use NewE to get an error instead.
 func New(options ...PrometheusOption) *Prometheus {
+	p, err := NewE(options...)
+	if err != nil {
+		panic(fmt.Sprintf("ginprom: invalid options:\n%v", err))
+	}
+	return p
+}
+
+// NewE is like New but returns an error reporting all the invalid options
+// instead of panicking.
+func NewE(options ...PrometheusOption) (*Prometheus, error) {
 	p := &Prometheus{
 		MetricsPath:               defaultPath,
 		Namespace:                 defaultNs,
@@ -275,6 +443,7 @@ func New(options ...PrometheusOption) *Prometheus {
 		HandlerNameFunc:           defaultHandlerNameFunc,
 		RequestPathFunc:           defaultRequestPathFunc,
 		HostFunc:                  defaultHostFunc,
+		PanicCode:                 defaultPanicCode,
 		RequestCounterMetricName:  defaultReqCntMetricName,
 		RequestDurationMetricName: defaultReqDurMetricName,
 		RequestSizeMetricName:     defaultReqSzMetricName,
@@ -284,88 +453,258 @@ func New(options ...PrometheusOption) *Prometheus {
 		NativeHistogramBucketFactor:     1.1,
 		NativeHistogramMaxBucketNumber:  100,
 		NativeHistogramMinResetDuration: 1 * time.Hour,
+		maxDecodedBodySize:              defaultMaxDecodedBodySize,
 	}
 	p.customGauges.values = make(map[string]prometheus.GaugeVec)
 	p.customCounters.values = make(map[string]prometheus.CounterVec)
 	p.customCounterLabels = make([]string, 0)
 	p.customHistograms.values = make(map[string]prometheus.HistogramVec)
 
+	p.routeChains.values = make(map[string]int)
+
 	p.Ignored.values = make(map[string]bool)
 	for _, option := range options {
 		option(p)
 	}
 
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	if p.isolatedRegistry && p.Registry == nil {
+		p.Registry = prometheus.NewRegistry()
+	}
+
 	p.register()
 	if p.Engine != nil {
 		p.Engine.GET(p.MetricsPath, p.prometheusHandler(p.Token))
 	}
 
-	return p
+	return p, nil
 }
 
 func (p *Prometheus) getRegistererAndGatherer() (prometheus.Registerer, prometheus.Gatherer) {
-	if p.Registry == nil {
-		return prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if p.Registry != nil {
+		registerer, gatherer = p.Registry, p.Registry
+	}
+	if p.Registerer != nil {
+		registerer = p.Registerer
+	}
+	if p.Gatherer != nil {
+		gatherer = p.Gatherer
+	}
+	return registerer, gatherer
+}
+
+// Gather implements prometheus.Gatherer, gathering the metrics from the
+// registry used by this instance.
+func (p *Prometheus) Gather() ([]*dto.MetricFamily, error) {
+	_, gatherer := p.getRegistererAndGatherer()
+	return gatherer.Gather()
+}
+
+// Gatherers returns a prometheus.Gatherers combining the metrics of the given
+// instances, which allows to expose the metrics of multiple instances on a
+// single endpoint. Instances sharing the same registry are gathered only once.
+// Instances using different registries must be distinguished, using Instance
+// or different namespaces or subsystems, for their metrics not to collide.
+func Gatherers(instances ...*Prometheus) prometheus.Gatherers {
+	gs := prometheus.Gatherers{}
+	seen := make(map[prometheus.Gatherer]bool)
+	for _, p := range instances {
+		_, gatherer := p.getRegistererAndGatherer()
+		if reflect.TypeOf(gatherer).Comparable() {
+			if seen[gatherer] {
+				continue
+			}
+			seen[gatherer] = true
+		}
+		gs = append(gs, gatherer)
 	}
-	return p.Registry, p.Registry
+	return gs
 }
 
 func (p *Prometheus) register() {
 	p.reqCnt = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: p.Namespace,
-			Subsystem: p.Subsystem,
-			Name:      p.RequestCounterMetricName,
-			Help:      "How many HTTP requests processed, partitioned by status code and HTTP method.",
-		},
-		append([]string{"code", "method", "handler", "host", "path"}, p.customCounterLabels...),
+		p.counterOpts(p.RequestCounterMetricName, "How many HTTP requests processed, partitioned by status code and HTTP method."),
+		p.requestCounterLabels(),
 	)
 	p.mustRegister(p.reqCnt)
 
-	var reqDurOpts prometheus.HistogramOpts
-	if p.nativeHistogram {
-		reqDurOpts = prometheus.HistogramOpts{
-			Namespace:                       p.Namespace,
-			Subsystem:                       p.Subsystem,
-			NativeHistogramBucketFactor:     p.NativeHistogramBucketFactor,
-			NativeHistogramMaxBucketNumber:  p.NativeHistogramMaxBucketNumber,
-			NativeHistogramMinResetDuration: p.NativeHistogramMinResetDuration,
-			Name:                            p.RequestDurationMetricName,
-			Help:                            "The HTTP request latency bucket.",
-		}
-
-	} else {
-		reqDurOpts = prometheus.HistogramOpts{
-			Namespace: p.Namespace,
-			Subsystem: p.Subsystem,
-			Buckets:   p.BucketsSize,
-			Name:      p.RequestDurationMetricName,
-			Help:      "The HTTP request latency bucket.",
-		}
-	}
-
-	p.reqDur = prometheus.NewHistogramVec(reqDurOpts, []string{"method", "path", "host"})
+	p.reqDur = prometheus.NewHistogramVec(
+		p.histogramOpts(p.RequestDurationMetricName, "The HTTP request latency bucket.", p.BucketsSize),
+		[]string{"method", "path", "host"},
+	)
 	p.mustRegister(p.reqDur)
 
 	p.reqSz = prometheus.NewSummary(
-		prometheus.SummaryOpts{
-			Namespace: p.Namespace,
-			Subsystem: p.Subsystem,
-			Name:      p.RequestSizeMetricName,
-			Help:      "The HTTP request sizes in bytes.",
-		},
+		p.summaryOpts(p.RequestSizeMetricName, "The HTTP request sizes in bytes."),
 	)
 	p.mustRegister(p.reqSz)
 
 	p.resSz = prometheus.NewSummary(
-		prometheus.SummaryOpts{
-			Namespace: p.Namespace,
-			Subsystem: p.Subsystem,
-			Name:      p.ResponseSizeMetricName,
-			Help:      "The HTTP response sizes in bytes.",
-		},
+		p.summaryOpts(p.ResponseSizeMetricName, "The HTTP response sizes in bytes."),
 	)
 	p.mustRegister(p.resSz)
+
+	p.panicCnt = prometheus.NewCounterVec(
+		p.counterOpts(defaultPanicCntMetricName, "How many HTTP handlers panicked, partitioned by path and handler."),
+		[]string{"path", "handler"},
+	)
+	p.mustRegister(p.panicCnt)
+
+	if p.runtimeMetrics {
+		p.registerRuntimeCollectors()
+	}
+
+	if p.engineMetrics {
+		p.mustRegister(newEngineCollector(p))
+	}
+
+	if len(p.dbs) > 0 {
+		p.mustRegister(newDBStatsCollector(p))
+	}
+
+	if p.timeToFirstByte {
+		p.ttfbDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultTTFBMetricName, "The HTTP time to first byte bucket.", p.BucketsSize),
+			[]string{"method", "path", "host"},
+		)
+		p.mustRegister(p.ttfbDur)
+	}
+
+	if p.measureRequestBody {
+		p.reqBodyWire = prometheus.NewSummary(
+			p.summaryOpts(defaultReqBodyWireMetricName, "The HTTP request body sizes in bytes, as sent on the wire."),
+		)
+		p.reqBodyDec = prometheus.NewSummary(
+			p.summaryOpts(defaultReqBodyDecodedMetricName, "The HTTP request body sizes in bytes, once decoded according to the Content-Encoding."),
+		)
+		p.mustRegister(p.reqBodyWire, p.reqBodyDec)
+	}
+
+	if p.responseEncoding {
+		p.resWire = prometheus.NewCounterVec(
+			p.counterOpts(defaultResWireMetricName, "How many HTTP response bytes were sent on the wire, partitioned by method, path and content encoding."),
+			[]string{"method", "path", "encoding"},
+		)
+		p.resUncomp = prometheus.NewCounterVec(
+			p.counterOpts(defaultResUncompressedMetricName, "How many HTTP response bytes were written before compression, partitioned by method, path and content encoding."),
+			[]string{"method", "path", "encoding"},
+		)
+		p.mustRegister(p.resWire, p.resUncomp)
+	}
+
+	if p.streamMetrics {
+		p.activeConns = prometheus.NewGaugeVec(
+			p.gaugeOpts(defaultActiveConnsMetricName, "How many long-lived connections are currently open, partitioned by path and kind."),
+			[]string{"path", "kind"},
+		)
+		p.connDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultConnDurMetricName, "The long-lived connections duration bucket.", defaultConnDurBuckets),
+			[]string{"path", "kind"},
+		)
+		p.streamMsgs = prometheus.NewCounterVec(
+			p.counterOpts(defaultStreamMsgsMetricName, "How many messages were sent on long-lived connections, partitioned by path and kind."),
+			[]string{"path", "kind"},
+		)
+		p.streamBytes = prometheus.NewCounterVec(
+			p.counterOpts(defaultStreamBytesMetricName, "How many bytes went through long-lived connections, partitioned by path and kind."),
+			[]string{"path", "kind"},
+		)
+		p.mustRegister(p.activeConns, p.connDur, p.streamMsgs, p.streamBytes)
+	}
+
+	if len(p.objectives) > 0 {
+		p.registerObjectives()
+	}
+
+	if p.apdex > 0 || len(p.apdexRoutes) > 0 {
+		p.registerApdex()
+	}
+
+	if p.errorMetrics || p.errorClassifier != nil {
+		p.registerErrors()
+	}
+
+	if p.phaseMetrics {
+		p.phaseDur = prometheus.NewHistogramVec(
+			p.histogramOpts(defaultPhaseDurMetricName, "The time spent in the phases of the HTTP requests timed with StartTimer, partitioned by path and phase.", p.BucketsSize),
+			[]string{"path", "phase"},
+		)
+		p.mustRegister(p.phaseDur)
+	}
+
+	if p.CancelCode != "" {
+		p.cancelCnt = prometheus.NewCounterVec(
+			p.counterOpts(defaultCancelledMetricName, "How many HTTP requests were cancelled by the client before the handlers returned, partitioned by method and path."),
+			[]string{"method", "path"},
+		)
+		p.mustRegister(p.cancelCnt)
+	}
+}
+
+// InitializeRoutes creates the zero-valued request counter and duration series
+// of every route registered on the engine, for the status codes and hosts set
+// with the PreInitialize and PreInitializeHosts options. Ignored routes and the
+// metrics route are skipped. It is called automatically on the first request
+// or scrape when the PreInitialize option is used, but can be called
+// explicitly once all the routes are registered.
+func (p *Prometheus) InitializeRoutes() {
+	if p.Engine == nil {
+		return
+	}
+
+	codes := p.preInitCodes
+	if len(codes) == 0 {
+		codes = []int{http.StatusOK}
+	}
+	// The handler and path labels are the ones of the default HandlerNameFunc
+	// and RequestPathFunc, which can't be combined with PreInitialize
+	for _, r := range p.Engine.Routes() {
+		if r.Path == p.MetricsPath || p.isIgnored(r.Path) {
+			continue
+		}
+		for _, host := range p.preInitHosts {
+			for _, code := range codes {
+				p.reqCnt.WithLabelValues(p.counterLabelValues(strconv.Itoa(code), r.Method, r.Handler, host, r.Path)...)
+			}
+			p.reqDur.WithLabelValues(r.Method, r.Path, host)
+		}
+	}
+}
+
+// initializeRoutes initializes the routes series once, if enabled.
+func (p *Prometheus) initializeRoutes() {
+	if p.preInitCodes == nil {
+		return
+	}
+	p.preInitOnce.Do(p.InitializeRoutes)
+}
+
+// recordRouteChain stores the length of the handlers chain of the route, which
+// is only known once a request is served.
+func (p *Prometheus) recordRouteChain(c *gin.Context) {
+	key := c.Request.Method + " " + c.FullPath()
+	p.routeChains.RLock()
+	_, ok := p.routeChains.values[key]
+	p.routeChains.RUnlock()
+	if ok {
+		return
+	}
+
+	p.routeChains.Lock()
+	defer p.routeChains.Unlock()
+	p.routeChains.values[key] = len(c.HandlerNames())
+}
+
+func (p *Prometheus) routeChainLength(method, path string) (int, bool) {
+	p.routeChains.RLock()
+	defer p.routeChains.RUnlock()
+	n, ok := p.routeChains.values[method+" "+path]
+	return n, ok
 }
 
 func (p *Prometheus) isIgnored(path string) bool {
@@ -380,6 +719,7 @@ func (p *Prometheus) isIgnored(path string) bool {
 func (p *Prometheus) Instrument() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
+		p.initializeRoutes()
 		path := p.RequestPathFunc(c)
 
 		if path == "" || p.isIgnored(path) {
@@ -387,27 +727,201 @@ func (p *Prometheus) Instrument() gin.HandlerFunc {
 			return
 		}
 
-		reqSz := computeApproximateRequestSize(c.Request)
+		if p.engineMetrics && c.FullPath() != "" {
+			p.recordRouteChain(c)
+		}
 
-		c.Next()
+		req := &request{
+			start: start,
+			path:  path,
+			size:  computeApproximateRequestSize(c.Request),
+		}
+
+		// http.NoBody is kept so handlers can still compare the body with it
+		if p.measureRequestBody && c.Request.Body != nil && c.Request.Body != http.NoBody {
+			req.body = newBodyCounter(c.Request.Body, c.Request.Header.Get("Content-Encoding"), p.maxDecodedBodySize)
+			c.Request.Body = req.body
+			req.size = computeRequestHeaderSize(c.Request)
+		}
+
+		if p.timeToFirstByte || p.streamMetrics || p.responseEncoding {
+			w := newResponseWriter(c.Writer, start)
+			if p.streamMetrics {
+				w.streams, w.request, w.path = p, c.Request, path
+			}
+			req.writer = w
+			c.Writer = w
+			c.Set(responseWriterKey, w)
+			defer func() {
+				w.endStream()
+				c.Writer = w.ResponseWriter
+			}()
+		}
 
-		status := strconv.Itoa(c.Writer.Status())
-		elapsed := float64(time.Since(start)) / float64(time.Second)
-		resSz := float64(c.Writer.Size())
+		if p.phaseMetrics {
+			req.phases = &phases{durations: make(map[string]time.Duration)}
+			c.Set(phasesKey, req.phases)
+		}
 
-		host := p.HostFunc(c)
-		labels := []string{status, c.Request.Method, p.HandlerNameFunc(c), host, path}
-		if p.customCounterLabelsProvider != nil {
-			extraLabels := p.customCounterLabelsProvider(c)
-			for _, label := range p.customCounterLabels {
-				labels = append(labels, extraLabels[label])
+		// A panicking handler never returns to this middleware, record the
+		// request with the panic code before letting the panic go through
+		defer func() {
+			if rec := recover(); rec != nil {
+				p.panicCnt.WithLabelValues(path, p.HandlerNameFunc(c)).Inc()
+				p.observe(c, req, p.PanicCode)
+				panic(rec)
 			}
+		}()
+
+		// Handlers may replace the request context, keep the one of the client
+		ctx := c.Request.Context()
+
+		c.Next()
+
+		if p.CancelCode != "" && errors.Is(ctx.Err(), context.Canceled) {
+			p.cancelCnt.WithLabelValues(c.Request.Method, path).Inc()
+			p.observe(c, req, p.CancelCode)
+			return
 		}
+		p.observe(c, req, strconv.Itoa(c.Writer.Status()))
+	}
+}
 
-		p.reqCnt.WithLabelValues(labels...).Inc()
+// request holds the state of a request instrumented by the middleware.
+type request struct {
+	start time.Time
+	path  string
+	// size is the approximate request size, or the size of the request line
+	// and headers when the body is measured.
+	size   int
+	body   *bodyCounter
+	writer *responseWriter
+	phases *phases
+}
+
+// requestSize records the measured body sizes, if any, and returns the
+// request size including the body bytes read from the wire.
+func (p *Prometheus) requestSize(req *request) int {
+	if req.body == nil {
+		return req.size
+	}
+	wire, decoded := req.body.sizes()
+	p.reqBodyWire.Observe(float64(wire))
+	if decoded >= 0 {
+		p.reqBodyDec.Observe(float64(decoded))
+	}
+	return req.size + int(wire)
+}
+
+// observe records the request metrics once the handler chain is done
+func (p *Prometheus) observe(c *gin.Context, req *request, status string) {
+	duration := time.Since(req.start)
+	elapsed := float64(duration) / float64(time.Second)
+	resSz := float64(c.Writer.Size())
+	path := req.path
+
+	host := p.HostFunc(c)
+	labels := p.counterLabelValues(status, c.Request.Method, p.HandlerNameFunc(c), host, path)
+	if p.customCounterLabelsProvider != nil {
+		extraLabels := p.customCounterLabelsProvider(c)
+		for _, label := range p.customCounterLabels {
+			labels = append(labels, extraLabels[label])
+		}
+	}
+
+	p.reqCnt.WithLabelValues(labels...).Inc()
+	w := req.writer
+	latency := w == nil || !w.isStream() || p.streamLatency
+	if latency {
 		p.reqDur.WithLabelValues(c.Request.Method, path, host).Observe(elapsed)
-		p.reqSz.Observe(float64(reqSz))
-		p.resSz.Observe(resSz)
+	}
+	if len(p.objectives) > 0 {
+		p.observeObjectives(path, status, duration, latency)
+	}
+	if p.apdexCnt != nil && latency {
+		p.observeApdex(c.Request.Method, path, host, status, duration)
+	}
+	if p.errCnt != nil {
+		p.observeErrors(c, path)
+	}
+	if req.phases != nil {
+		p.observePhases(req.phases, path)
+	}
+	if w != nil && p.ttfbDur != nil {
+		p.ttfbDur.WithLabelValues(c.Request.Method, path, host).Observe(w.timeToFirstByte())
+	}
+	if w != nil && p.responseEncoding {
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+		// The inner writer always counts the bytes sent on the wire, while
+		// the ones going through the wrapper are the uncompressed ones when
+		// it sits inside the compression middleware
+		wire := int64(max(w.ResponseWriter.Size(), 0))
+		v, _ := c.Get(wireSizeKey)
+		ws, outer := v.(*wireSize)
+		switch {
+		case w.uncompressed >= 0:
+			p.resUncomp.WithLabelValues(c.Request.Method, path, encoding).Add(float64(w.uncompressed))
+		case encoding == "identity" || outer || w.written != wire:
+			p.resUncomp.WithLabelValues(c.Request.Method, path, encoding).Add(float64(w.written))
+		}
+		if outer {
+			ws.method, ws.path, ws.encoding, ws.recorded = c.Request.Method, path, encoding, true
+		} else {
+			p.resWire.WithLabelValues(c.Request.Method, path, encoding).Add(float64(wire))
+		}
+	}
+	p.reqSz.Observe(float64(p.requestSize(req)))
+	p.resSz.Observe(resSz)
+}
+
+// UncompressedSize is a gin middleware that must be registered after the
+// compression middleware when using ResponseEncodingMetrics, so the response
+// bytes written by the handlers can be counted before being compressed.
+// Example:
+// r.Use(p.Instrument(), gzip.Gzip(gzip.DefaultCompression), p.UncompressedSize())
+func (p *Prometheus) UncompressedSize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, _ := c.Get(responseWriterKey)
+		w, ok := v.(*responseWriter)
+		if !ok {
+			c.Next()
+			return
+		}
+		w.uncompressed = 0
+		c.Writer = &uncompressedWriter{ResponseWriter: c.Writer, w: w}
+		c.Next()
+	}
+}
+
+// wireSize holds the labels of a response recorded by the Instrument middleware,
+// for WireSize to count its bytes sent on the wire once compressed.
+type wireSize struct {
+	method, path, encoding string
+	recorded               bool
+}
+
+// WireSize is a gin middleware that must be registered before the compression
+// middleware when using ResponseEncodingMetrics with the Instrument middleware
+// registered after it. Compression middlewares only write what they buffered
+// once the handlers returned, so the bytes sent on the wire are counted here.
+// Example:
+// r.Use(p.WireSize(), gzip.Gzip(gzip.DefaultCompression), p.Instrument())
+func (p *Prometheus) WireSize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.responseEncoding {
+			c.Next()
+			return
+		}
+		w := c.Writer
+		ws := &wireSize{}
+		c.Set(wireSizeKey, ws)
+		c.Next()
+		if ws.recorded {
+			p.resWire.WithLabelValues(ws.method, ws.path, ws.encoding).Add(float64(max(w.Size(), 0)))
+		}
 	}
 }
 
@@ -420,10 +934,18 @@ func (p *Prometheus) Use(e *gin.Engine) {
 
 func (p *Prometheus) prometheusHandler(token string) gin.HandlerFunc {
 	registerer, gatherer := p.getRegistererAndGatherer()
+	if len(p.exposed) > 0 {
+		gatherer = Gatherers(append([]*Prometheus{p}, p.exposed...)...)
+	}
+	if len(p.ConstLabels) > 0 {
+		registerer = prometheus.WrapRegistererWith(p.ConstLabels, registerer)
+	}
 	h := promhttp.InstrumentMetricHandler(
 		registerer, promhttp.HandlerFor(gatherer, p.HandlerOpts),
 	)
 	return func(c *gin.Context) {
+		p.initializeRoutes()
+
 		if token == "" {
 			h.ServeHTTP(c.Writer, c.Request)
 			return
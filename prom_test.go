@@ -1,8 +1,19 @@
 package ginprom
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,8 +21,11 @@ import (
 	"github.com/appleboy/gofight/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,6 +34,7 @@ func unregister(p *Prometheus) {
 	prometheus.Unregister(p.reqDur)
 	prometheus.Unregister(p.reqSz)
 	prometheus.Unregister(p.resSz)
+	prometheus.Unregister(p.panicCnt)
 }
 
 func init() {
@@ -385,6 +400,39 @@ func TestThreadedInstrument(t *testing.T) {
 	unregister(p)
 }
 
+func TestInstrumentPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []PrometheusOption
+		code    string
+	}{
+		{"default", nil, "500"},
+		{"custom", []PrometheusOption{PanicCode("panic")}, "panic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			p := New(append(tt.options, Engine(r), Registry(prometheus.NewRegistry()))...)
+			r.Use(gin.RecoveryWithWriter(io.Discard), p.Instrument())
+			r.GET("/panic", func(c *gin.Context) { panic("oops") })
+
+			g := gofight.New()
+			g.GET("/panic").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusInternalServerError, r.Code)
+			})
+
+			g.GET(p.MetricsPath).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				body := r.Body.String()
+				assert.Equal(t, http.StatusOK, r.Code)
+				assert.Contains(t, body, fmt.Sprintf(`code="%s"`, tt.code))
+				assert.Contains(t, body, `path="/panic"`)
+				assert.Contains(t, body, prometheus.BuildFQName(p.Namespace, p.Subsystem, "panics_total"))
+			})
+		})
+	}
+}
+
 func TestEmptyRouter(t *testing.T) {
 	r := gin.New()
 	p := New()
@@ -560,6 +608,95 @@ func TestCustomNativeHistogram(t *testing.T) {
 	assert.True(t, found)
 }
 
+func TestHybridHistogram(t *testing.T) {
+	r := gin.New()
+	registry := prometheus.NewRegistry()
+	p := New(Engine(r), Registry(registry), HybridHistogram(true), BucketSize([]float64{0.1, 1}))
+	p.AddCustomHistogram("custom_histogram", "test histogram", []string{"url"})
+	r.Use(p.Instrument())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	g := gofight.New()
+	g.GET("/").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	assert.NoError(t, p.AddCustomHistogramValue("custom_histogram", []string{"/"}, 0.45))
+
+	mfs, err := registry.Gather()
+	assert.NoError(t, err)
+
+	buckets := map[string]int{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			h := m.GetHistogram()
+			if h == nil {
+				continue
+			}
+			assert.Equal(t, int32(3), h.GetSchema(), mf.GetName())
+			assert.Equal(t, uint64(1), h.GetSampleCount(), mf.GetName())
+			buckets[mf.GetName()] = len(h.GetBucket())
+		}
+	}
+	assert.Equal(t, 2, buckets["gin_gonic_request_duration"])
+	assert.Equal(t, len(prometheus.DefBuckets), buckets["gin_gonic_custom_histogram"])
+}
+
+// gatherProtobuf scrapes the metrics endpoint using the delimited protobuf
+// exposition format, the only one exposing native histograms.
+func gatherProtobuf(t *testing.T, r *gin.Engine, path string) map[string]*io_prometheus_client.MetricFamily {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mfs := map[string]*io_prometheus_client.MetricFamily{}
+	dec := expfmt.NewDecoder(w.Body, expfmt.ResponseFormat(w.Header()))
+	for {
+		mf := &io_prometheus_client.MetricFamily{}
+		if err := dec.Decode(mf); err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			return mfs
+		}
+		mfs[mf.GetName()] = mf
+	}
+}
+
+func TestNativeHistogramZeroThreshold(t *testing.T) {
+	r := gin.New()
+	p := New(
+		Engine(r),
+		Registry(prometheus.NewRegistry()),
+		NativeHistogram(true),
+		NativeHistogramZeroThreshold(0.001),
+	)
+	p.AddCustomHistogram("default_settings", "test histogram", []string{"url"})
+	p.AddCustomHistogramWithSettings("custom_settings", "test histogram", []string{"url"}, HistogramSettings{
+		NativeHistogramBucketFactor:     2,
+		NativeHistogramMaxBucketNumber:  2,
+		NativeHistogramMaxZeroThreshold: 1,
+	})
+	for _, v := range []float64{0.0005, 0.01, 0.1, 10} {
+		assert.NoError(t, p.AddCustomHistogramValue("default_settings", []string{"/"}, v))
+		assert.NoError(t, p.AddCustomHistogramValue("custom_settings", []string{"/"}, v))
+	}
+
+	mfs := gatherProtobuf(t, r, p.MetricsPath)
+
+	h := mfs["gin_gonic_default_settings"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, int32(3), h.GetSchema())
+	assert.Equal(t, 0.001, h.GetZeroThreshold())
+	assert.Equal(t, uint64(1), h.GetZeroCount())
+	assert.Empty(t, h.GetBucket())
+
+	// Reaching the max bucket number widens the zero bucket
+	h = mfs["gin_gonic_custom_settings"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, int32(0), h.GetSchema())
+	assert.Greater(t, h.GetZeroThreshold(), 0.001)
+	assert.LessOrEqual(t, h.GetZeroThreshold(), 1.0)
+	assert.Greater(t, h.GetZeroCount(), uint64(1))
+	assert.Equal(t, uint64(4), h.GetSampleCount())
+}
+
 func TestIgnore(t *testing.T) {
 	r := gin.New()
 	ipath := "/ping"
@@ -878,3 +1015,1014 @@ func TestCustomCounterCorrectRegistry(t *testing.T) {
 		return false
 	})
 }
+
+func TestTimeToFirstByte(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), TimeToFirstByte(true))
+	r.Use(p.Instrument())
+
+	r.GET("/stream", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		time.Sleep(50 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+	// Setting the status doesn't send anything, the first byte is the write
+	r.GET("/slow", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		time.Sleep(50 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+
+	g := gofight.New()
+	for _, path := range []string{"/stream", "/slow"} {
+		g.GET(path).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+			assert.Equal(t, "done", r.Body.String())
+		})
+	}
+
+	sum := func(h *prometheus.HistogramVec, path string) float64 {
+		m := &io_prometheus_client.Metric{}
+		assert.NoError(t, h.WithLabelValues("GET", path, "").(prometheus.Metric).Write(m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		return m.GetHistogram().GetSampleSum()
+	}
+	assert.Less(t, sum(p.ttfbDur, "/stream"), 0.05)
+	assert.GreaterOrEqual(t, sum(p.reqDur, "/stream"), 0.05)
+	assert.GreaterOrEqual(t, sum(p.ttfbDur, "/slow"), 0.05)
+}
+
+func TestStreamMetrics(t *testing.T) {
+	r := gin.New()
+	registry := prometheus.NewRegistry()
+	p := New(Engine(r), Registry(registry), StreamMetrics(true))
+	r.Use(p.Instrument())
+
+	r.GET("/sse", func(c *gin.Context) {
+		i := 0
+		c.Stream(func(w io.Writer) bool {
+			c.SSEvent("message", i)
+			i++
+			return i < 3
+		})
+	})
+	r.GET("/ws", func(c *gin.Context) {
+		conn, rw, err := c.Writer.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+	})
+	r.GET("/plain", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		i := 0
+		c.Stream(func(w io.Writer) bool {
+			_, _ = w.Write([]byte("chunk"))
+			i++
+			return i < 3
+		})
+	})
+	// A single flush, as done by reverse proxies, isn't a stream
+	r.GET("/flushed", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+		c.Writer.Flush()
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/sse")
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 3, strings.Count(string(body), "event:message"))
+
+	for _, path := range []string{"/plain", "/stream", "/flushed"} {
+		res, err = http.Get(srv.URL + path)
+		assert.NoError(t, err)
+		res.Body.Close()
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	res.Body.Close()
+
+	// The response may be received before the handler returns and the
+	// request gets recorded
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(p.reqCnt) == 5 && testutil.CollectAndCount(p.connDur) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 0., testutil.ToFloat64(p.activeConns.WithLabelValues("/sse", "sse")))
+	assert.Equal(t, 0., testutil.ToFloat64(p.activeConns.WithLabelValues("/ws", "websocket")))
+	assert.Equal(t, 3., testutil.ToFloat64(p.streamMsgs.WithLabelValues("/sse", "sse")))
+	assert.Equal(t, float64(len(body)), testutil.ToFloat64(p.streamBytes.WithLabelValues("/sse", "sse")))
+	assert.Greater(t, testutil.ToFloat64(p.streamBytes.WithLabelValues("/ws", "websocket")), 0.)
+	assert.Equal(t, 3., testutil.ToFloat64(p.streamMsgs.WithLabelValues("/stream", "stream")))
+	assert.Equal(t, 15., testutil.ToFloat64(p.streamBytes.WithLabelValues("/stream", "stream")))
+
+	// Only the plain and flushed requests are part of the request duration
+	// histogram
+	assert.Equal(t, 2, testutil.CollectAndCount(p.reqDur))
+	m := &io_prometheus_client.Metric{}
+	assert.NoError(t, p.reqDur.WithLabelValues("GET", "/flushed", strings.TrimPrefix(srv.URL, "http://")).(prometheus.Metric).Write(m))
+	assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+	assert.Equal(t, 3, testutil.CollectAndCount(p.activeConns), "no connection should be tracked for /flushed")
+}
+
+func TestStreamMetricsOpen(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), StreamMetrics(true))
+	r.Use(p.Instrument())
+
+	sent, release := make(chan struct{}), make(chan struct{})
+	r.GET("/sse", func(c *gin.Context) {
+		time.Sleep(50 * time.Millisecond)
+		for i := range 3 {
+			c.SSEvent("message", i)
+			c.Writer.Flush()
+		}
+		close(sent)
+		<-release
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/sse")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	<-sent
+
+	// The messages and bytes are reported while the connection is open
+	assert.Equal(t, 1., testutil.ToFloat64(p.activeConns.WithLabelValues("/sse", "sse")))
+	assert.Equal(t, 3., testutil.ToFloat64(p.streamMsgs.WithLabelValues("/sse", "sse")))
+	assert.Greater(t, testutil.ToFloat64(p.streamBytes.WithLabelValues("/sse", "sse")), 0.)
+
+	close(release)
+	_, _ = io.ReadAll(res.Body)
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(p.connDur) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The duration includes the time elapsed before the first event
+	m := &io_prometheus_client.Metric{}
+	assert.NoError(t, p.connDur.WithLabelValues("/sse", "sse").(prometheus.Metric).Write(m))
+	assert.GreaterOrEqual(t, m.GetHistogram().GetSampleSum(), 0.05)
+}
+
+func TestMeasureRequestBody(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte(strings.Repeat("ginprom", 100)))
+	_ = zw.Close()
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		chunked  bool
+		max      int64
+		decoded  float64
+	}{
+		{"plain", []byte("hello world"), "", false, 0, 11},
+		{"chunked", []byte("hello world"), "", true, 0, 11},
+		{"gzip", gz.Bytes(), "gzip", false, 0, 700},
+		// Decoding stops past the maximum, the decoded size isn't reported
+		{"gzip too large", gz.Bytes(), "gzip", false, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			registry := prometheus.NewRegistry()
+			opts := []PrometheusOption{Engine(r), Registry(registry), MeasureRequestBody(true)}
+			if tt.max != 0 {
+				opts = append(opts, MaxDecodedBodySize(tt.max))
+			}
+			p := New(opts...)
+			r.Use(p.Instrument())
+
+			var received []byte
+			r.POST("/upload", func(c *gin.Context) {
+				received, _ = io.ReadAll(c.Request.Body)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if tt.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.body, received, "handlers must read the body unchanged")
+
+			sums := map[string]float64{}
+			mfs, err := registry.Gather()
+			assert.NoError(t, err)
+			for _, mf := range mfs {
+				for _, m := range mf.GetMetric() {
+					if m.GetSummary() != nil {
+						sums[mf.GetName()] = m.GetSummary().GetSampleSum()
+					}
+				}
+			}
+
+			assert.Equal(t, float64(len(tt.body)), sums["gin_gonic_request_body_wire_size_bytes"])
+			assert.Equal(t, tt.decoded, sums["gin_gonic_request_body_decoded_size_bytes"])
+			assert.Greater(t, sums["gin_gonic_request_size_bytes"], float64(len(tt.body)))
+		})
+	}
+
+	// Requests without a body keep http.NoBody
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), MeasureRequestBody(true))
+	r.Use(p.Instrument())
+	r.GET("/", func(c *gin.Context) {
+		assert.Equal(t, http.NoBody, c.Request.Body)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	_, err = NewE(Registry(prometheus.NewRegistry()), MaxDecodedBodySize(-1))
+	assert.ErrorContains(t, err, "max decoded body size must not be negative")
+}
+
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipWriter) Write(data []byte) (int, error) {
+	return g.gz.Write(data)
+}
+
+func (g *gzipWriter) WriteString(s string) (int, error) {
+	return g.gz.Write([]byte(s))
+}
+
+func gzipMiddleware(c *gin.Context) {
+	gz := gzip.NewWriter(c.Writer)
+	c.Header("Content-Encoding", "gzip")
+	c.Writer = &gzipWriter{c.Writer, gz}
+	c.Next()
+	_ = gz.Close()
+}
+
+func TestResponseEncodingMetrics(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), ResponseEncodingMetrics(true))
+	r.Use(p.Instrument())
+
+	payload := strings.Repeat("ginprom", 100)
+	r.GET("/plain", func(c *gin.Context) { c.String(http.StatusOK, payload) })
+	r.GET("/gzip", gzipMiddleware, p.UncompressedSize(), func(c *gin.Context) { c.String(http.StatusOK, payload) })
+
+	g := gofight.New()
+	g.GET("/plain").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+	})
+
+	var wire int
+	g.GET("/gzip").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Equal(t, "gzip", r.HeaderMap.Get("Content-Encoding"))
+		wire = r.Body.Len()
+	})
+
+	assert.Equal(t, float64(len(payload)), testutil.ToFloat64(p.resWire.WithLabelValues("GET", "/plain", "identity")))
+	assert.Equal(t, float64(len(payload)), testutil.ToFloat64(p.resUncomp.WithLabelValues("GET", "/plain", "identity")))
+	assert.Equal(t, float64(wire), testutil.ToFloat64(p.resWire.WithLabelValues("GET", "/gzip", "gzip")))
+	assert.Equal(t, float64(len(payload)), testutil.ToFloat64(p.resUncomp.WithLabelValues("GET", "/gzip", "gzip")))
+	assert.Less(t, wire, len(payload))
+
+	// Compression registered before the instrumentation
+	for _, wireSize := range []bool{true, false} {
+		r := gin.New()
+		p := New(Engine(r), Registry(prometheus.NewRegistry()), ResponseEncodingMetrics(true))
+		if wireSize {
+			r.Use(p.WireSize())
+		}
+		r.Use(gzipMiddleware, p.Instrument())
+		r.GET("/gzip", func(c *gin.Context) { c.String(http.StatusOK, payload) })
+
+		g.GET("/gzip").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+			wire = r.Body.Len()
+		})
+
+		assert.Equal(t, float64(len(payload)), testutil.ToFloat64(p.resUncomp.WithLabelValues("GET", "/gzip", "gzip")))
+		if wireSize {
+			assert.Equal(t, float64(wire), testutil.ToFloat64(p.resWire.WithLabelValues("GET", "/gzip", "gzip")))
+		} else {
+			// Only what was written before the handlers returned is counted
+			assert.LessOrEqual(t, testutil.ToFloat64(p.resWire.WithLabelValues("GET", "/gzip", "gzip")), float64(wire))
+		}
+	}
+}
+
+func TestInstance(t *testing.T) {
+	admin := gin.New()
+	public := gin.New()
+
+	registry := prometheus.NewRegistry()
+
+	var pa, pp *Prometheus
+	assert.NotPanics(t, func() {
+		pa = New(Engine(admin), Registry(registry), Instance("admin"))
+		pp = New(Engine(public), Registry(registry), Instance("public"))
+	}, "instances sharing a registry must not collide")
+
+	admin.Use(pa.Instrument())
+	public.Use(pp.Instrument())
+	admin.GET("/admin", func(c *gin.Context) { c.Status(http.StatusOK) })
+	public.GET("/public", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	g := gofight.New()
+	g.GET("/admin").Run(admin, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	g.GET("/public").Run(public, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	g.GET(pa.MetricsPath).Run(admin, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Contains(t, r.Body.String(), `gin_instance="admin",host="",method="GET",path="/admin"`)
+		assert.Contains(t, r.Body.String(), `gin_instance="public",host="",method="GET",path="/public"`)
+	})
+
+	assert.Len(t, Gatherers(pa, pp), 1, "instances sharing a registry are gathered once")
+}
+
+func TestIsolatedRegistry(t *testing.T) {
+	admin := gin.New()
+	public := gin.New()
+
+	pp := New(Engine(public), IsolatedRegistry(), Instance("public"))
+	pa := New(Engine(admin), IsolatedRegistry(), Instance("admin"), ExposeWith(pp))
+	assert.NotNil(t, pa.Registry)
+	assert.NotNil(t, pp.Registry)
+	assert.NotEqual(t, pa.Registry, pp.Registry)
+
+	admin.Use(pa.Instrument())
+	public.Use(pp.Instrument())
+	admin.GET("/admin", func(c *gin.Context) { c.Status(http.StatusOK) })
+	public.GET("/public", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	g := gofight.New()
+	g.GET("/admin").Run(admin, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	g.GET("/public").Run(public, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	g.GET(pp.MetricsPath).Run(public, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Contains(t, r.Body.String(), `path="/public"`)
+		assert.NotContains(t, r.Body.String(), `path="/admin"`)
+	})
+
+	g.GET(pa.MetricsPath).Run(admin, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Contains(t, r.Body.String(), `path="/public"`)
+		assert.Contains(t, r.Body.String(), `path="/admin"`)
+	})
+
+	assert.Len(t, Gatherers(pa, pp), 2)
+}
+
+func TestRegistererGatherer(t *testing.T) {
+	r := gin.New()
+	reg := prometheus.NewRegistry()
+	other := prometheus.NewRegistry()
+	other.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "other_gauge", Help: "other gauge"}))
+
+	p := New(
+		Engine(r),
+		Registerer(prometheus.WrapRegistererWithPrefix("app_", reg)),
+		Gatherer(prometheus.Gatherers{reg, other}),
+	)
+	r.Use(p.Instrument())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	g := gofight.New()
+	g.GET("/").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	g.GET(p.MetricsPath).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Contains(t, r.Body.String(), "app_gin_gonic_requests_total")
+		assert.Contains(t, r.Body.String(), "other_gauge")
+		assert.NotContains(t, r.Body.String(), "\ngin_gonic_requests_total")
+	})
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := New(Registry(reg), RuntimeMetrics(collectors.MetricsGC))
+
+	mfs, err := reg.Gather()
+	assert.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	assert.True(t, names["go_goroutines"], "go collector should be registered")
+	assert.True(t, names["go_gc_heap_allocs_bytes_total"], "runtime/metrics rules should be applied")
+	assert.True(t, names["process_start_time_seconds"], "process collector should be registered")
+	assert.True(t, names[prometheus.BuildFQName(p.Namespace, p.Subsystem, "build_info")], "build info should be registered")
+
+	assert.NotPanics(t, func() {
+		New(Registry(reg), Namespace("other"), RuntimeMetrics(collectors.MetricsGC))
+	}, "registering the runtime collectors twice should not panic")
+	assert.Panics(t, func() {
+		New(Registry(reg), Namespace("another"), RuntimeMetrics(collectors.MetricsScheduler))
+	}, "a go collector exposing other metrics conflicts with the registered one")
+
+	_, err = NewE(RuntimeMetrics(collectors.MetricsGC))
+	assert.ErrorContains(t, err, "RuntimeMetrics rules can't be used with the default registry")
+}
+
+func TestEngineMetrics(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), EngineMetrics(true))
+	r.Use(p.Instrument())
+
+	noop := func(c *gin.Context) {}
+	r.GET("/user/:id", noop, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/user", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	g := gofight.New()
+	g.GET("/user/1").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	g.GET(p.MetricsPath).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		body := r.Body.String()
+		assert.Equal(t, http.StatusOK, r.Code)
+		assert.Contains(t, body, `gin_gonic_route_info{handler="github.com/Depado/ginprom.TestEngineMetrics.func2",method="GET",path="/user/:id"} 1`)
+		assert.Contains(t, body, `gin_gonic_route_info{handler="github.com/Depado/ginprom.TestEngineMetrics.func3",method="POST",path="/user"} 1`)
+		assert.Contains(t, body, `gin_gonic_routes{method="GET"} 2`)
+		assert.Contains(t, body, `gin_gonic_routes{method="POST"} 1`)
+		assert.Contains(t, body, `gin_gonic_route_chain_length{method="GET",path="/user/:id"} 3`)
+		assert.NotContains(t, body, `gin_gonic_route_chain_length{method="POST"`)
+	})
+}
+
+func TestPreInitialize(t *testing.T) {
+	r := gin.New()
+	p := New(
+		Engine(r),
+		Registry(prometheus.NewRegistry()),
+		Ignore("/ignored"),
+		PreInitialize(200, 500),
+		PreInitializeHosts("example.com"),
+	)
+	r.Use(p.Instrument())
+
+	r.GET("/user/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/user", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.GET("/ignored", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	g := gofight.New()
+	g.GET(p.MetricsPath).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+		body := r.Body.String()
+		assert.Equal(t, http.StatusOK, r.Code)
+		for _, code := range []string{"200", "500"} {
+			assert.Contains(t, body, fmt.Sprintf(`code="%s",handler="github.com/Depado/ginprom.TestPreInitialize.func1",host="example.com",method="GET",path="/user/:id"} 0`, code))
+			assert.Contains(t, body, fmt.Sprintf(`code="%s",handler="github.com/Depado/ginprom.TestPreInitialize.func2",host="example.com",method="POST",path="/user"} 0`, code))
+		}
+		assert.Contains(t, body, `gin_gonic_request_duration_count{host="example.com",method="GET",path="/user/:id"} 0`)
+		assert.NotContains(t, body, `path="/ignored"`)
+		assert.NotContains(t, body, fmt.Sprintf(`path="%s"`, p.MetricsPath))
+	})
+
+	series := testutil.CollectAndCount(p.reqCnt)
+	// Unlike gofight, httptest sets the Host header of the request
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/1", nil))
+	assert.Equal(t, series, testutil.CollectAndCount(p.reqCnt))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("200", "GET", "github.com/Depado/ginprom.TestPreInitialize.func1", "example.com", "/user/:id")))
+	m := &io_prometheus_client.Metric{}
+	assert.NoError(t, p.reqDur.WithLabelValues("GET", "/user/:id", "example.com").(prometheus.Metric).Write(m))
+	assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+
+	_, err := NewE(Registry(prometheus.NewRegistry()), PreInitialize(200))
+	assert.ErrorContains(t, err, "PreInitialize requires the hosts returned by HostFunc to be set with PreInitializeHosts")
+	_, err = NewE(
+		Registry(prometheus.NewRegistry()),
+		PreInitialize(200),
+		PreInitializeHosts("example.com"),
+		HandlerNameFunc(func(c *gin.Context) string { return "handler" }),
+		RequestPathFunc(func(c *gin.Context) string { return c.Request.URL.Path }),
+		CustomCounterLabels([]string{"client"}, func(c *gin.Context) map[string]string { return nil }),
+	)
+	assert.ErrorContains(t, err, "PreInitialize can't be used with a custom HandlerNameFunc")
+	assert.ErrorContains(t, err, "PreInitialize can't be used with a custom RequestPathFunc")
+	assert.ErrorContains(t, err, "PreInitialize can't be used with CustomCounterLabels")
+}
+
+func TestConfig(t *testing.T) {
+	yml := `
+path: /internal/metrics
+namespace: ""
+subsystem: app
+buckets: [0.1, 0.5, 1]
+ignore: [/health]
+native_histogram:
+  enabled: true
+  bucket_factor: 1.1
+  min_reset_duration: 2h
+metric_names:
+  request_counter: hits_total
+`
+	c, err := ParseConfigYAML([]byte(yml))
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(2*time.Hour), c.NativeHistogram.MinResetDuration)
+
+	js, err := json.Marshal(c)
+	assert.NoError(t, err)
+	fromJSON, err := ParseConfigJSON(js)
+	assert.NoError(t, err)
+	assert.Equal(t, c, fromJSON)
+
+	t.Setenv("TEST_TOKEN", "secret")
+	t.Setenv("TEST_BUCKETS", "0.2, 2")
+	assert.NoError(t, c.LoadEnv("TEST"))
+	assert.Equal(t, "secret", c.Token)
+	assert.Equal(t, []float64{0.2, 2}, c.Buckets)
+
+	opts, err := c.Options()
+	assert.NoError(t, err)
+	p := New(append(opts, Registry(prometheus.NewRegistry()))...)
+	assert.Equal(t, "/internal/metrics", p.MetricsPath)
+	assert.Equal(t, "", p.Namespace)
+	assert.Equal(t, "app", p.Subsystem)
+	assert.Equal(t, "secret", p.Token)
+	assert.Equal(t, []float64{0.2, 2}, p.BucketsSize)
+	assert.True(t, p.isIgnored("/health"))
+	assert.True(t, p.nativeHistogram)
+	assert.Equal(t, 2*time.Hour, p.NativeHistogramMinResetDuration)
+	assert.Equal(t, "hits_total", p.RequestCounterMetricName)
+
+	_, err = ParseConfigYAML([]byte("unknown: true"))
+	assert.Error(t, err)
+
+	t.Setenv("TEST_NATIVE_HISTOGRAM", "maybe")
+	assert.Error(t, c.LoadEnv("TEST"))
+
+	bad := Config{
+		Path:            "metrics",
+		Buckets:         []float64{1, 0.5},
+		Ignore:          []string{"health"},
+		NativeHistogram: NativeHistogramConfig{BucketFactor: 1},
+		MetricNames:     MetricNamesConfig{RequestSize: "request-size"},
+	}
+	_, err = bad.Options()
+	assert.Error(t, err)
+	for _, msg := range []string{"path", "buckets", "ignored path", "bucket factor", "request-size"} {
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestNewE(t *testing.T) {
+	p, err := NewE(Registry(prometheus.NewRegistry()))
+	assert.NoError(t, err)
+	assert.NotNil(t, p)
+
+	_, err = NewE(
+		Registry(prometheus.NewRegistry()),
+		Path("metrics"),
+		BucketSize([]float64{1, 1}),
+		NativeHistogramBucketFactor(0),
+		NativeHistogramMaxZeroThreshold(-1),
+		RequestCounterMetricName("requests-total"),
+		RequestSizeMetricName("request_duration"),
+		ConstLabels(prometheus.Labels{"path": "x"}),
+	)
+	assert.Error(t, err)
+	for _, msg := range []string{
+		`path "metrics" must start with a slash`,
+		"buckets must be strictly increasing",
+		"bucket factor must be greater than 1",
+		"max zero threshold must not be negative",
+		`invalid metric name "gin_gonic_requests-total"`,
+		`metric name "gin_gonic_request_duration" is used more than once`,
+		`constant label "path" conflicts`,
+	} {
+		assert.ErrorContains(t, err, msg)
+	}
+
+	assert.PanicsWithValue(t, "ginprom: invalid options:\n"+`path "metrics" must start with a slash`, func() {
+		New(Registry(prometheus.NewRegistry()), Path("metrics"))
+	})
+}
+
+func TestObjectives(t *testing.T) {
+	r := gin.New()
+	p := New(
+		Engine(r),
+		Registry(prometheus.NewRegistry()),
+		Objectives(
+			Objective{Name: "api", Route: "/api/*", Target: 0.99, Latency: 50 * time.Millisecond},
+			Objective{Name: "users", Route: "/users/:id", Target: 0.999},
+		),
+	)
+	r.Use(p.Instrument())
+	r.GET("/api/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/api/slow", func(c *gin.Context) {
+		time.Sleep(60 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/api/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	g := gofight.New()
+	for _, path := range []string{"/api/ok", "/api/slow", "/api/fail", "/users/1"} {
+		g.GET(path).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	}
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(p.sloTotal.WithLabelValues("api", "availability")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.sloGood.WithLabelValues("api", "availability")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(p.sloTotal.WithLabelValues("api", "latency")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.sloGood.WithLabelValues("api", "latency")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.sloGood.WithLabelValues("users", "availability")))
+	assert.Equal(t, 3, testutil.CollectAndCount(p.sloTotal))
+
+	rules, err := p.SLORules()
+	assert.NoError(t, err)
+	assert.Len(t, rules.Groups, 4)
+	assert.Len(t, rules.Groups[0].Rules, len(sloWindows))
+	assert.Len(t, rules.Groups[1].Rules, 2*len(sloBurnRates))
+	assert.Len(t, rules.Groups[3].Rules, len(sloBurnRates))
+
+	out, err := rules.YAML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `record: gin_gonic_slo:error_ratio:rate5m`)
+	assert.Contains(t, string(out), `1 - (sum by (objective, sli) (rate(gin_gonic_slo_good_requests_total{objective="api"}[5m])) / sum by (objective, sli) (rate(gin_gonic_slo_requests_total{objective="api"}[5m])))`)
+	assert.Contains(t, string(out), `gin_gonic_slo:error_ratio:rate1h{objective="api",sli="latency"} > 14.4 * (1 - 0.99) and gin_gonic_slo:error_ratio:rate5m{objective="api",sli="latency"} > 14.4 * (1 - 0.99)`)
+
+	_, err = NewE(Registry(prometheus.NewRegistry()), Objectives(Objective{Route: "/[", Target: 1}))
+	assert.ErrorContains(t, err, "has no name")
+	assert.ErrorContains(t, err, "target must be between 0 and 1")
+	assert.ErrorContains(t, err, `invalid route "/["`)
+}
+
+func TestGenerateRules(t *testing.T) {
+	ns := "myapp"
+	f, err := GenerateRules(Config{
+		Namespace:   &ns,
+		MetricNames: MetricNamesConfig{RequestCounter: "hits_total"},
+	})
+	assert.NoError(t, err)
+	out, err := f.YAML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `expr: sum by (method, path) (rate(myapp_gonic_hits_total{code=~"5.*"}[5m]))`)
+	assert.Contains(t, string(out), `rate(myapp_gonic_request_duration_bucket[5m])`)
+
+	f, err = GenerateRules(Config{NativeHistogram: NativeHistogramConfig{Enabled: true}})
+	assert.NoError(t, err)
+	out, err = f.YAML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `histogram_quantile(0.99, sum by (method, path) (rate(gin_gonic_request_duration[5m])))`)
+
+	_, err = GenerateRules(Config{MetricNames: MetricNamesConfig{RequestCounter: "hits-total"}})
+	assert.Error(t, err)
+}
+
+func TestGenerateDashboard(t *testing.T) {
+	sys := ""
+	out, err := GenerateDashboard(Config{Subsystem: &sys})
+	assert.NoError(t, err)
+
+	var d map[string]any
+	assert.NoError(t, json.Unmarshal(out, &d))
+	assert.Len(t, d["panels"], 4)
+	assert.Contains(t, string(out), `label_values(gin_requests_total, path)`)
+	assert.Contains(t, string(out), `sum by (path, le) (rate(gin_request_duration_bucket{method=~\"$method\", path=~\"$path\"}[$__rate_interval]))`)
+}
+
+func TestApdex(t *testing.T) {
+	r := gin.New()
+	p := New(
+		Engine(r),
+		Registry(prometheus.NewRegistry()),
+		Apdex(20*time.Millisecond),
+		ApdexRoute("/slow", 200*time.Millisecond),
+	)
+	r.Use(p.Instrument())
+	r.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/sleep/:ms", func(c *gin.Context) {
+		ms, _ := strconv.Atoi(c.Param("ms"))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	g := gofight.New()
+	for _, path := range []string{"/fast", "/sleep/30", "/sleep/100", "/slow", "/fail"} {
+		g.GET(path).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	}
+
+	count := func(path, class string) float64 {
+		return testutil.ToFloat64(p.apdexCnt.WithLabelValues("GET", path, "", class))
+	}
+	assert.Equal(t, float64(1), count("/fast", "satisfied"))
+	assert.Equal(t, float64(1), count("/sleep/:ms", "tolerating"))
+	assert.Equal(t, float64(1), count("/sleep/:ms", "frustrated"))
+	assert.Equal(t, float64(1), count("/slow", "satisfied"))
+	assert.Equal(t, float64(1), count("/fail", "frustrated"))
+
+	_, err := NewE(Registry(prometheus.NewRegistry()), ApdexRoute("/", 0))
+	assert.ErrorContains(t, err, `apdex threshold of route "/" must be positive`)
+}
+
+func TestStatusCode(t *testing.T) {
+	setup := func(opts ...PrometheusOption) *Prometheus {
+		r := gin.New()
+		p := New(append(opts,
+			Engine(r),
+			Registry(prometheus.NewRegistry()),
+			HandlerNameFunc(func(c *gin.Context) string { return "handler" }),
+		)...)
+		r.Use(gin.RecoveryWithWriter(io.Discard), p.Instrument())
+		r.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		r.GET("/cancel", func(c *gin.Context) { c.Status(499) })
+		r.GET("/panic", func(c *gin.Context) { panic("boom") })
+		g := gofight.New()
+		for _, path := range []string{"/", "/cancel", "/panic"} {
+			g.GET(path).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+		}
+		return p
+	}
+
+	p := setup(StatusCode(StatusCodeClass))
+	assert.Equal(t, 3, testutil.CollectAndCount(p.reqCnt))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("2xx", "GET", "handler", "", "/")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("4xx", "GET", "handler", "", "/cancel")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("5xx", "GET", "handler", "", "/panic")))
+
+	p = setup(StatusCode(StatusCodeExactAndClass))
+	assert.Equal(t, 3, testutil.CollectAndCount(p.reqCnt))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("204", "GET", "handler", "", "/", "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("499", "GET", "handler", "", "/cancel", "4xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("500", "GET", "handler", "", "/panic", "5xx")))
+
+	p = setup(StatusCode(StatusCodeExactAndClass), PanicCode("panic"), StatusCodeFunc(func(code int) string {
+		if code == 499 {
+			return "client_cancel"
+		}
+		return statusClass(code)
+	}))
+	assert.Equal(t, 3, testutil.CollectAndCount(p.reqCnt))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("2xx", "GET", "handler", "", "/", "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("client_cancel", "GET", "handler", "", "/cancel", "4xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("panic", "GET", "handler", "", "/panic", "panic")))
+
+	r := gin.New()
+	p = New(Engine(r), Registry(prometheus.NewRegistry()), StatusCode(StatusCodeClass), PreInitialize(200, 201, 404), PreInitializeHosts("example.com"))
+	r.GET("/", func(c *gin.Context) {})
+	p.InitializeRoutes()
+	assert.Equal(t, 2, testutil.CollectAndCount(p.reqCnt))
+
+	_, err := NewE(Registry(prometheus.NewRegistry()), StatusCode(42))
+	assert.ErrorContains(t, err, "unknown status code label mode 42")
+}
+
+func TestCancelCode(t *testing.T) {
+	r := gin.New()
+	p := New(
+		Engine(r),
+		Registry(prometheus.NewRegistry()),
+		HandlerNameFunc(func(c *gin.Context) string { return "handler" }),
+		CancelCode("499"),
+	)
+	r.Use(p.Instrument())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.GET("/", func(c *gin.Context) {
+		// The client goes away while the request is being handled
+		cancel()
+		c.Request = c.Request.WithContext(context.Background())
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("499", "GET", "handler", "example.com", "/")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.reqCnt.WithLabelValues("200", "GET", "handler", "example.com", "/")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.cancelCnt.WithLabelValues("GET", "/")))
+}
+
+func TestErrorMetrics(t *testing.T) {
+	errNotFound := errors.New("not found")
+
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), ErrorMetrics(true))
+	r.Use(p.Instrument())
+	r.POST("/user", func(c *gin.Context) {
+		var body struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			_ = c.Error(err).SetType(gin.ErrorTypeBind)
+		}
+		_ = c.Error(errNotFound)
+		_ = c.Error(errors.New("public")).SetType(gin.ErrorTypePublic)
+		c.Status(http.StatusOK)
+	})
+
+	g := gofight.New()
+	g.POST("/user").SetJSON(gofight.D{}).Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	assert.Equal(t, 3, testutil.CollectAndCount(p.errCnt))
+	for _, typ := range []string{"bind", "private", "public"} {
+		assert.Equal(t, float64(1), testutil.ToFloat64(p.errCnt.WithLabelValues("POST", "/user", typ)), typ)
+	}
+
+	r = gin.New()
+	p = New(Engine(r), Registry(prometheus.NewRegistry()), ErrorClassifier(func(err error) string {
+		if errors.Is(err, errNotFound) {
+			return "not_found"
+		}
+		return "internal"
+	}))
+	r.Use(p.Instrument())
+	r.GET("/", func(c *gin.Context) {
+		_ = c.Error(fmt.Errorf("user: %w", errNotFound))
+		_ = c.Error(errors.New("boom"))
+	})
+	g.GET("/").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.errCnt.WithLabelValues("GET", "/", "private", "not_found")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.errCnt.WithLabelValues("GET", "/", "private", "internal")))
+}
+
+func TestTimeMiddleware(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()))
+	auth := func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Next()
+	}
+	r.Use(p.Instrument(), p.TimeMiddleware("auth", auth))
+	r.GET("/user/:id", p.TimeMiddleware("user", func(c *gin.Context) {
+		time.Sleep(40 * time.Millisecond)
+		c.Status(http.StatusOK)
+	}))
+
+	g := gofight.New()
+	g.GET("/user/1").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	sum := func(name string) float64 {
+		m := &io_prometheus_client.Metric{}
+		assert.NoError(t, p.mwDur.WithLabelValues(name, "/user/:id").(prometheus.Metric).Write(m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		return m.GetHistogram().GetSampleSum()
+	}
+	assert.InDelta(t, 0.02, sum("auth"), 0.015)
+	assert.InDelta(t, 0.04, sum("user"), 0.015)
+}
+
+func TestStartTimer(t *testing.T) {
+	r := gin.New()
+	p := New(Engine(r), Registry(prometheus.NewRegistry()), PhaseMetrics(true))
+	r.Use(p.Instrument())
+	r.GET("/user/:id", func(c *gin.Context) {
+		for range 2 {
+			stop := StartTimer(c, "db")
+			time.Sleep(10 * time.Millisecond)
+			stop()
+			stop()
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func(c *gin.Context) {
+			defer wg.Done()
+			defer StartTimer(c, "http")()
+			time.Sleep(5 * time.Millisecond)
+		}(c.Copy())
+		wg.Wait()
+		c.Status(http.StatusOK)
+	})
+
+	g := gofight.New()
+	g.GET("/user/1").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+
+	sum := func(phase string) float64 {
+		m := &io_prometheus_client.Metric{}
+		assert.NoError(t, p.phaseDur.WithLabelValues("/user/:id", phase).(prometheus.Metric).Write(m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		return m.GetHistogram().GetSampleSum()
+	}
+	assert.InDelta(t, 0.02, sum("db"), 0.01)
+	assert.InDelta(t, 0.005, sum("http"), 0.005)
+
+	// Without the option, timers are no-ops
+	r = gin.New()
+	p = New(Engine(r), Registry(prometheus.NewRegistry()))
+	r.Use(p.Instrument())
+	r.GET("/noop", func(c *gin.Context) { StartTimer(c, "db")() })
+	g.GET("/noop").Run(r, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {})
+	assert.Nil(t, p.phaseDur)
+}
+
+func TestRoundTripper(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	p := New(Registry(prometheus.NewRegistry()))
+	client := &http.Client{Transport: p.RoundTripper(srv.Client().Transport, func(r *http.Request) string {
+		return "/users/:id"
+	})}
+	// A second RoundTripper shares the metrics registered by the first one
+	assert.NotNil(t, p.RoundTripper(nil, nil))
+
+	for range 2 {
+		res, err := client.Get(srv.URL + "/users/1")
+		assert.NoError(t, err)
+		res.Body.Close()
+	}
+	srv.Close()
+	_, err := client.Post(srv.URL+"/users/1", "text/plain", nil)
+	assert.Error(t, err)
+
+	m := &p.client
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.reqCnt.WithLabelValues(host, "GET", "202", "/users/:id")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.reqCnt.WithLabelValues(host, "POST", "error", "/users/:id")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.inFlight.WithLabelValues(host)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.reqDur))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.tlsDur))
+}
+
+// fakeDriver is a database/sql driver whose connections do nothing.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+func TestDBStats(t *testing.T) {
+	sql.Register("ginprom_fake", fakeDriver{})
+	users, err := sql.Open("ginprom_fake", "")
+	assert.NoError(t, err)
+	defer users.Close()
+	orders, err := sql.Open("ginprom_fake", "")
+	assert.NoError(t, err)
+	defer orders.Close()
+	users.SetMaxOpenConns(10)
+
+	conn, err := users.Conn(context.Background())
+	assert.NoError(t, err)
+	idle, err := users.Conn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, idle.Close())
+	defer conn.Close()
+
+	reg := prometheus.NewRegistry()
+	New(Registry(reg), Namespace("app"), Subsystem(""), DBStats("users", users), DBStats("orders", orders))
+
+	expected := `
+# HELP app_db_in_use_connections The number of connections currently in use.
+# TYPE app_db_in_use_connections gauge
+app_db_in_use_connections{db="orders"} 0
+app_db_in_use_connections{db="users"} 1
+# HELP app_db_max_open_connections Maximum number of open connections to the database.
+# TYPE app_db_max_open_connections gauge
+app_db_max_open_connections{db="orders"} 0
+app_db_max_open_connections{db="users"} 10
+# HELP app_db_open_connections The number of established connections both in use and idle.
+# TYPE app_db_open_connections gauge
+app_db_open_connections{db="orders"} 0
+app_db_open_connections{db="users"} 2
+# HELP app_db_idle_connections The number of idle connections.
+# TYPE app_db_idle_connections gauge
+app_db_idle_connections{db="orders"} 0
+app_db_idle_connections{db="users"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"app_db_in_use_connections", "app_db_max_open_connections", "app_db_open_connections", "app_db_idle_connections"))
+	assert.Equal(t, 18, testutil.CollectAndCount(newDBStatsCollector(&Prometheus{dbs: map[string]*sql.DB{"users": users, "orders": orders}})))
+
+	_, err = NewE(Registry(prometheus.NewRegistry()), DBStats("nil", nil))
+	assert.ErrorContains(t, err, `database "nil" is nil`)
+}
@@ -1,6 +1,13 @@
 package ginprom
 
-import "net/http"
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
 
 // From https://github.com/DanielHeckrath/gin-prometheus/blob/master/gin_prometheus.go
 func computeApproximateRequestSize(r *http.Request) int {
@@ -26,3 +33,120 @@ func computeApproximateRequestSize(r *http.Request) int {
 	}
 	return s
 }
+
+// computeRequestHeaderSize computes the size of the request line and headers
+// as they were sent on the wire. Unlike computeApproximateRequestSize, the
+// body is not included.
+func computeRequestHeaderSize(r *http.Request) int {
+	// Request line: METHOD SP URI SP PROTO CRLF
+	s := len(r.Method) + 1 + len(r.RequestURI) + 1 + len(r.Proto) + 2
+	if r.RequestURI == "" && r.URL != nil {
+		s += len(r.URL.RequestURI())
+	}
+
+	// Headers: Name: value CRLF
+	for name, values := range r.Header {
+		for _, value := range values {
+			s += len(name) + 2 + len(value) + 2
+		}
+	}
+	// Host and Transfer-Encoding are removed from the headers by net/http
+	if r.Host != "" {
+		s += len("Host: ") + len(r.Host) + 2
+	}
+	if len(r.TransferEncoding) > 0 {
+		s += len("Transfer-Encoding: ") + len(strings.Join(r.TransferEncoding, ", ")) + 2
+	}
+
+	// Blank line ending the headers
+	return s + 2
+}
+
+// errDecodedBodyTooLarge stops the decoding of a request body once the maximum
+// decoded size is reached.
+var errDecodedBodyTooLarge = errors.New("decoded body too large")
+
+// bodyCounter wraps a request body to count the bytes actually read by the
+// handlers. When the body is compressed with a supported Content-Encoding,
+// the read bytes are also decoded on the side to count the decoded size, up
+// to a maximum so a small compressed body can't cause unbounded work. The
+// handlers still read the body as it was sent.
+type bodyCounter struct {
+	io.ReadCloser
+
+	wire     int64
+	decoded  int64
+	identity bool
+
+	pw     *io.PipeWriter
+	failed bool
+	done   chan struct{}
+}
+
+func newBodyCounter(body io.ReadCloser, encoding string, maxDecoded int64) *bodyCounter {
+	b := &bodyCounter{ReadCloser: body, decoded: -1}
+
+	var decoder func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		b.identity = true
+		return b
+	case "gzip", "x-gzip":
+		decoder = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		decoder = func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }
+	default:
+		// Unsupported encoding, the decoded size is unknown
+		return b
+	}
+	if maxDecoded <= 0 {
+		return b
+	}
+
+	pr, pw := io.Pipe()
+	b.pw = pw
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		r, err := decoder(pr)
+		if err == nil {
+			// A body that isn't entirely read yields the decoded size of
+			// what was read
+			var n int64
+			n, err = io.Copy(io.Discard, io.LimitReader(r, maxDecoded+1))
+			if n > maxDecoded {
+				err = errDecodedBodyTooLarge
+			} else {
+				b.decoded = n
+			}
+		}
+		// Fail any further write once decoding is done
+		pr.CloseWithError(err)
+	}()
+	return b
+}
+
+func (b *bodyCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.wire += int64(n)
+	if b.pw != nil && !b.failed && n > 0 {
+		if _, werr := b.pw.Write(p[:n]); werr != nil {
+			b.failed = true
+		}
+	}
+	return n, err
+}
+
+// sizes returns the number of bytes read from the body as sent on the wire
+// and once decoded. The decoded size is -1 when it can't be determined.
+func (b *bodyCounter) sizes() (wire, decoded int64) {
+	if b.identity {
+		return b.wire, b.wire
+	}
+	if b.done == nil {
+		return b.wire, b.decoded
+	}
+	b.pw.Close()
+	<-b.done
+	return b.wire, b.decoded
+}
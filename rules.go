@@ -0,0 +1,71 @@
+package ginprom
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// RuleFile is a Prometheus rule file, which can be loaded with the
+// rule_files setting of the Prometheus configuration once marshaled.
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a group of recording and alerting rules.
+type RuleGroup struct {
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// Rule is either a recording rule, when Record is set, or an alerting rule.
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// YAML marshals the rule file.
+func (f RuleFile) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// selector returns a PromQL selector of the given metric, matching the given
+// labels along with the constant labels.
+func selector(name string, constLabels, labels map[string]string) string {
+	all := make(map[string]string, len(constLabels)+len(labels))
+	maps.Copy(all, constLabels)
+	maps.Copy(all, labels)
+	if len(all) == 0 {
+		return name
+	}
+
+	matchers := make([]string, 0, len(all))
+	for _, k := range slices.Sorted(maps.Keys(all)) {
+		matchers = append(matchers, k+"="+strconv.Quote(all[k]))
+	}
+	return name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// grouping returns the labels to aggregate by, the constant labels being
+// kept so the series of distinct instances aren't mixed.
+func grouping(constLabels map[string]string, labels ...string) string {
+	return strings.Join(append(slices.Sorted(maps.Keys(constLabels)), labels...), ", ")
+}
@@ -0,0 +1,200 @@
+package ginprom
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultSLOTotalMetricName = "slo_requests_total"
+	defaultSLOGoodMetricName  = "slo_good_requests_total"
+)
+
+// Service level indicators recorded for each objective.
+const (
+	sliAvailability = "availability"
+	sliLatency      = "latency"
+)
+
+// sloWindows are the windows the error ratios are recorded over, used by the
+// burn rate alerts.
+var sloWindows = []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}
+
+// sloBurnRates are the multi-window burn rate alerts, as recommended by the
+// Google SRE workbook.
+var sloBurnRates = []struct {
+	severity    string
+	long, short string
+	factor      string
+}{
+	{"page", "1h", "5m", "14.4"},
+	{"page", "6h", "30m", "6"},
+	{"ticket", "1d", "2h", "3"},
+	{"ticket", "3d", "6h", "1"},
+}
+
+// Objective is a service level objective for the routes matching Route. A
+// Route ending with * matches all the paths starting with what precedes it,
+// for example /api/*, other routes are matched using path.Match. Requests
+// are matched against the path label, so against the route pattern with the
+// default RequestPathFunc.
+//
+// The availability SLI counts the requests not ending with a 5xx status as
+// good ones. The latency SLI, only recorded when Latency is set, counts the
+// requests served within Latency as good ones. Target is the ratio of good
+// requests aimed for, for example 0.99.
+type Objective struct {
+	Name    string
+	Route   string
+	Target  float64
+	Latency time.Duration
+}
+
+func (o Objective) matches(p string) bool {
+	if prefix, ok := strings.CutSuffix(o.Route, "*"); ok {
+		return strings.HasPrefix(p, prefix)
+	}
+	ok, _ := path.Match(o.Route, p)
+	return ok
+}
+
+func (o Objective) slis() []string {
+	if o.Latency > 0 {
+		return []string{sliAvailability, sliLatency}
+	}
+	return []string{sliAvailability}
+}
+
+func validateObjectives(objectives []Objective) []error {
+	var errs []error
+	names := make(map[string]bool)
+	for _, o := range objectives {
+		if o.Name == "" {
+			errs = append(errs, fmt.Errorf("objective for route %q has no name", o.Route))
+		} else if names[o.Name] {
+			errs = append(errs, fmt.Errorf("objective %q is declared more than once", o.Name))
+		}
+		names[o.Name] = true
+
+		if o.Target <= 0 || o.Target >= 1 {
+			errs = append(errs, fmt.Errorf("objective %q target must be between 0 and 1, got %v", o.Name, o.Target))
+		}
+		if o.Latency < 0 {
+			errs = append(errs, fmt.Errorf("objective %q latency must not be negative, got %v", o.Name, o.Latency))
+		}
+		if _, err := path.Match(o.Route, ""); err != nil || o.Route == "" {
+			errs = append(errs, fmt.Errorf("objective %q has an invalid route %q", o.Name, o.Route))
+		}
+	}
+	return errs
+}
+
+func (p *Prometheus) registerObjectives() {
+	p.sloTotal = prometheus.NewCounterVec(
+		p.counterOpts(defaultSLOTotalMetricName, "How many HTTP requests were subject to a service level objective, partitioned by objective and SLI."),
+		[]string{"objective", "sli"},
+	)
+	p.sloGood = prometheus.NewCounterVec(
+		p.counterOpts(defaultSLOGoodMetricName, "How many HTTP requests met a service level objective, partitioned by objective and SLI."),
+		[]string{"objective", "sli"},
+	)
+	p.mustRegister(p.sloTotal, p.sloGood)
+
+	for _, o := range p.objectives {
+		for _, sli := range o.slis() {
+			p.sloTotal.WithLabelValues(o.Name, sli)
+			p.sloGood.WithLabelValues(o.Name, sli)
+		}
+	}
+}
+
+// observeObjectives records the request in the objectives matching its path.
+// The latency SLI is skipped when the latency isn't meaningful, as for
+// long-lived connections.
+func (p *Prometheus) observeObjectives(path, status string, elapsed time.Duration, latency bool) {
+	for _, o := range p.objectives {
+		if !o.matches(path) {
+			continue
+		}
+		p.sloTotal.WithLabelValues(o.Name, sliAvailability).Inc()
+		if !strings.HasPrefix(status, "5") {
+			p.sloGood.WithLabelValues(o.Name, sliAvailability).Inc()
+		}
+		if o.Latency > 0 && latency {
+			p.sloTotal.WithLabelValues(o.Name, sliLatency).Inc()
+			if elapsed <= o.Latency {
+				p.sloGood.WithLabelValues(o.Name, sliLatency).Inc()
+			}
+		}
+	}
+}
+
+// SLORules returns the recording rules computing the error ratio of every
+// objective over multiple windows, and the multi-window burn rate alerts
+// firing when the error budget is consumed too fast.
+func (p *Prometheus) SLORules() (RuleFile, error) {
+	if len(p.objectives) == 0 {
+		return RuleFile{}, errors.New("no objective declared")
+	}
+	return sloRules(p.Namespace, p.Subsystem, p.ConstLabels, p.objectives), nil
+}
+
+func sloRules(namespace, subsystem string, constLabels map[string]string, objectives []Objective) RuleFile {
+	total := prometheus.BuildFQName(namespace, subsystem, defaultSLOTotalMetricName)
+	good := prometheus.BuildFQName(namespace, subsystem, defaultSLOGoodMetricName)
+	record := func(window string) string {
+		return prometheus.BuildFQName(namespace, subsystem, "slo") + ":error_ratio:rate" + window
+	}
+	by := grouping(constLabels, "objective", "sli")
+
+	var f RuleFile
+	for _, o := range objectives {
+		recording := RuleGroup{Name: "slo-" + o.Name + "-recording"}
+		for _, w := range sloWindows {
+			match := map[string]string{"objective": o.Name}
+			recording.Rules = append(recording.Rules, Rule{
+				Record: record(w),
+				Expr: fmt.Sprintf(
+					"1 - (sum by (%s) (rate(%s[%s])) / sum by (%s) (rate(%s[%s])))",
+					by, selector(good, constLabels, match), w,
+					by, selector(total, constLabels, match), w,
+				),
+			})
+		}
+
+		alerting := RuleGroup{Name: "slo-" + o.Name + "-alerting"}
+		budget := "(1 - " + strconv.FormatFloat(o.Target, 'g', -1, 64) + ")"
+		for _, sli := range o.slis() {
+			match := map[string]string{"objective": o.Name, "sli": sli}
+			for _, b := range sloBurnRates {
+				alerting.Rules = append(alerting.Rules, Rule{
+					Alert: "ErrorBudgetBurn",
+					Expr: fmt.Sprintf(
+						"%s > %s * %s and %s > %s * %s",
+						selector(record(b.long), constLabels, match), b.factor, budget,
+						selector(record(b.short), constLabels, match), b.factor, budget,
+					),
+					Labels: map[string]string{
+						"objective":    o.Name,
+						"sli":          sli,
+						"severity":     b.severity,
+						"long_window":  b.long,
+						"short_window": b.short,
+					},
+					Annotations: map[string]string{
+						"summary": fmt.Sprintf("Objective %s is burning its %s error budget %sx too fast", o.Name, sli, b.factor),
+					},
+				})
+			}
+		}
+
+		f.Groups = append(f.Groups, recording, alerting)
+	}
+	return f
+}
@@ -0,0 +1,67 @@
+package ginprom
+
+import (
+	"strconv"
+)
+
+// StatusCodeLabel is the way the status code of the requests is recorded in
+// the request counter.
+type StatusCodeLabel int
+
+const (
+	// StatusCodeExact records the exact status code in the code label, for
+	// example "404". This is the default.
+	StatusCodeExact StatusCodeLabel = iota
+	// StatusCodeClass records the status class in the code label, for example
+	// "4xx".
+	StatusCodeClass
+	// StatusCodeExactAndClass records the exact status code in the code label
+	// and the status class in an additional code_class label.
+	StatusCodeExactAndClass
+)
+
+// statusClass returns the class of a status code, such as "2xx".
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// requestCounterLabels returns the label names of the request counter.
+func (p *Prometheus) requestCounterLabels() []string {
+	labels := []string{"code", "method", "handler", "host", "path"}
+	if p.statusCodeLabel == StatusCodeExactAndClass {
+		labels = append(labels, "code_class")
+	}
+	return append(labels, p.customCounterLabels...)
+}
+
+// statusLabels returns the values of the status labels of the request
+// counter. The status is usually a status code, but may be any value set with
+// PanicCode, which is then used as is.
+func (p *Prometheus) statusLabels(status string) []string {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		if p.statusCodeLabel == StatusCodeExactAndClass {
+			return []string{status, status}
+		}
+		return []string{status}
+	}
+
+	value := status
+	switch {
+	case p.statusCodeFunc != nil:
+		value = p.statusCodeFunc(code)
+	case p.statusCodeLabel == StatusCodeClass:
+		value = statusClass(code)
+	}
+	if p.statusCodeLabel == StatusCodeExactAndClass {
+		return []string{value, statusClass(code)}
+	}
+	return []string{value}
+}
+
+// counterLabelValues returns the values of the request counter labels, the
+// custom labels excepted.
+func (p *Prometheus) counterLabelValues(status, method, handler, host, path string) []string {
+	codes := p.statusLabels(status)
+	return append([]string{codes[0], method, handler, host, path}, codes[1:]...)
+}
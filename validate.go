@@ -0,0 +1,168 @@
+package ginprom
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func validatePath(path string) error {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path %q must start with a slash", path)
+	}
+	return nil
+}
+
+func validateBuckets(buckets []float64) error {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("buckets must be strictly increasing, got %v after %v", buckets[i], buckets[i-1])
+		}
+	}
+	return nil
+}
+
+func validateBucketFactor(factor float64) error {
+	if factor <= 1 {
+		return fmt.Errorf("native histogram bucket factor must be greater than 1, got %v", factor)
+	}
+	return nil
+}
+
+func validateZeroThresholds(zero, maxZero float64) []error {
+	var errs []error
+	if zero < 0 && zero != prometheus.NativeHistogramZeroThresholdZero {
+		errs = append(errs, fmt.Errorf("native histogram zero threshold must not be negative, got %v", zero))
+	}
+	if maxZero < 0 {
+		errs = append(errs, fmt.Errorf("native histogram max zero threshold must not be negative, got %v", maxZero))
+	}
+	return errs
+}
+
+func validateMetricName(name string) error {
+	if !metricNameRe.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	return nil
+}
+
+// validate checks the options applied to the instance before its metrics are
+// registered, reporting all the problems found as a single joined error.
+func (p *Prometheus) validate() error {
+	var errs []error
+
+	if err := validatePath(p.MetricsPath); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateBuckets(p.BucketsSize); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateBucketFactor(p.NativeHistogramBucketFactor); err != nil {
+		errs = append(errs, err)
+	}
+	if p.NativeHistogramMinResetDuration < 0 {
+		errs = append(errs, fmt.Errorf("native histogram min reset duration must not be negative, got %v", p.NativeHistogramMinResetDuration))
+	}
+
+	errs = append(errs, validateZeroThresholds(p.NativeHistogramZeroThreshold, p.NativeHistogramMaxZeroThreshold)...)
+
+	if p.maxDecodedBodySize < 0 {
+		errs = append(errs, fmt.Errorf("max decoded body size must not be negative, got %d", p.maxDecodedBodySize))
+	}
+
+	if p.statusCodeLabel < StatusCodeExact || p.statusCodeLabel > StatusCodeExactAndClass {
+		errs = append(errs, fmt.Errorf("unknown status code label mode %d", p.statusCodeLabel))
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.dbs)) {
+		if p.dbs[name] == nil {
+			errs = append(errs, fmt.Errorf("database %q is nil", name))
+		}
+	}
+	if len(p.runtimeMetricsRules) > 0 && p.Registry == nil && p.Registerer == nil && !p.isolatedRegistry {
+		errs = append(errs, errors.New("RuntimeMetrics rules can't be used with the default registry, which already has a Go collector"))
+	}
+	errs = append(errs, p.validatePreInitialize()...)
+	errs = append(errs, validateObjectives(p.objectives)...)
+	errs = append(errs, validateApdex(p.apdex, p.apdexRoutes)...)
+
+	seen := make(map[string]bool)
+	for _, name := range []string{
+		p.RequestCounterMetricName,
+		p.RequestDurationMetricName,
+		p.RequestSizeMetricName,
+		p.ResponseSizeMetricName,
+		defaultPanicCntMetricName,
+	} {
+		fqName := prometheus.BuildFQName(p.Namespace, p.Subsystem, name)
+		if err := validateMetricName(fqName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[fqName] {
+			errs = append(errs, fmt.Errorf("metric name %q is used more than once", fqName))
+		}
+		seen[fqName] = true
+	}
+
+	labels := make(map[string]bool)
+	for _, label := range p.requestCounterLabels() {
+		if !labelNameRe.MatchString(label) {
+			errs = append(errs, fmt.Errorf("invalid label name %q", label))
+		}
+		if labels[label] {
+			errs = append(errs, fmt.Errorf("label %q is used more than once", label))
+		}
+		labels[label] = true
+	}
+	for _, label := range slices.Sorted(maps.Keys(p.ConstLabels)) {
+		if !labelNameRe.MatchString(label) {
+			errs = append(errs, fmt.Errorf("invalid constant label name %q", label))
+		}
+		if labels[label] {
+			errs = append(errs, fmt.Errorf("constant label %q conflicts with a variable label", label))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validatePreInitialize checks that the series created by PreInitialize are
+// the ones the requests produce, which is only known for the default handler
+// and path labels and without custom labels.
+func (p *Prometheus) validatePreInitialize() []error {
+	if p.preInitCodes == nil {
+		return nil
+	}
+
+	var errs []error
+	if len(p.preInitHosts) == 0 {
+		errs = append(errs, errors.New("PreInitialize requires the hosts returned by HostFunc to be set with PreInitializeHosts"))
+	}
+	if !sameFunc(p.HandlerNameFunc, defaultHandlerNameFunc) {
+		errs = append(errs, errors.New("PreInitialize can't be used with a custom HandlerNameFunc"))
+	}
+	if !sameFunc(p.RequestPathFunc, defaultRequestPathFunc) {
+		errs = append(errs, errors.New("PreInitialize can't be used with a custom RequestPathFunc"))
+	}
+	if len(p.customCounterLabels) > 0 {
+		errs = append(errs, errors.New("PreInitialize can't be used with CustomCounterLabels"))
+	}
+	return errs
+}
+
+func sameFunc(a, b func(c *gin.Context) string) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
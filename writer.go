@@ -0,0 +1,270 @@
+package ginprom
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of long-lived connections reported by the stream metrics.
+const (
+	streamKindStream    = "stream"
+	streamKindSSE       = "sse"
+	streamKindWebSocket = "websocket"
+	streamKindHijacked  = "hijacked"
+)
+
+// responseWriter wraps the gin.ResponseWriter of a request to observe what
+// happens to the response while the handler chain runs.
+type responseWriter struct {
+	gin.ResponseWriter
+
+	start     time.Time
+	firstByte time.Time
+
+	// written counts the bytes going through the writer, uncompressed counts
+	// the bytes written before compression when the UncompressedSize
+	// middleware is used, and is -1 otherwise.
+	written      int64
+	uncompressed int64
+
+	// flushes counts the calls to Flush and flushedAt is the value of written
+	// at the last one, telling whether body data was written in between.
+	flushes   int64
+	flushedAt int64
+
+	// streams is only set when stream metrics are enabled, stream is then
+	// created as soon as the response is detected as a long-lived one.
+	streams *Prometheus
+	request *http.Request
+	path    string
+	stream  *streamConn
+}
+
+func newResponseWriter(w gin.ResponseWriter, start time.Time) *responseWriter {
+	return &responseWriter{ResponseWriter: w, start: start, uncompressed: -1}
+}
+
+func (w *responseWriter) markFirstByte() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+}
+
+// timeToFirstByte returns the number of seconds elapsed between the start of
+// the request and the first write. Setting the status code with WriteHeader
+// doesn't count, since gin only sends the headers along with the first write.
+// If nothing was written by the handlers, the headers are only sent once the
+// chain returns, so the time elapsed until now is returned.
+func (w *responseWriter) timeToFirstByte() float64 {
+	if w.firstByte.IsZero() {
+		return time.Since(w.start).Seconds()
+	}
+	return w.firstByte.Sub(w.start).Seconds()
+}
+
+// isStream reports whether the response was detected as a long-lived one.
+func (w *responseWriter) isStream() bool {
+	return w.stream != nil
+}
+
+// detectStream starts tracking the response as a long-lived connection of the
+// given kind, if stream metrics are enabled and it isn't tracked already.
+func (w *responseWriter) detectStream(kind string) {
+	if w.streams == nil || w.stream != nil {
+		return
+	}
+	w.stream = newStreamConn(w.streams, w.path, kind, w.start)
+	if w.written > 0 {
+		w.stream.bytes.Add(float64(w.written))
+	}
+}
+
+func (w *responseWriter) isEventStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// endStream ends the tracking of a streamed response once the handler chain
+// returned. Hijacked connections are ended when they are closed instead.
+func (w *responseWriter) endStream() {
+	if w.stream == nil || w.stream.kind == streamKindWebSocket || w.stream.kind == streamKindHijacked {
+		return
+	}
+	w.stream.end()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	w.markFirstByte()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.markFirstByte()
+	if w.isEventStream() {
+		w.detectStream(streamKindSSE)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.written += int64(n)
+	if w.stream != nil {
+		w.stream.bytes.Add(float64(n))
+	}
+	return n, err
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.markFirstByte()
+	if w.isEventStream() {
+		w.detectStream(streamKindSSE)
+	}
+	n, err := w.ResponseWriter.WriteString(s)
+	w.written += int64(n)
+	if w.stream != nil {
+		w.stream.bytes.Add(float64(n))
+	}
+	return n, err
+}
+
+// Flush is considered as the end of a message. A response flushed again after
+// writing more body data is a streamed one, while a single flush, as done by
+// proxies once the headers are received, doesn't make it one.
+func (w *responseWriter) Flush() {
+	w.markFirstByte()
+	streaming := w.isStream()
+	if w.isEventStream() {
+		w.detectStream(streamKindSSE)
+	} else if w.flushes > 0 && w.written > w.flushedAt {
+		w.detectStream(streamKindStream)
+	}
+	w.flushes++
+	w.flushedAt = w.written
+	if w.stream != nil {
+		// The messages flushed before the stream was detected are part of it
+		if !streaming {
+			w.stream.messages.Add(float64(w.flushes))
+		} else {
+			w.stream.messages.Inc()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack takes over the connection, which is then tracked until it is closed.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.markFirstByte()
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err != nil || w.streams == nil {
+		return conn, rw, err
+	}
+
+	kind := streamKindHijacked
+	if strings.EqualFold(w.request.Header.Get("Upgrade"), "websocket") {
+		kind = streamKindWebSocket
+	}
+	w.detectStream(kind)
+	if w.stream == nil {
+		return conn, rw, err
+	}
+
+	// Only redirect the buffers through the counting connection when they are
+	// empty, resetting them would otherwise drop pending data
+	c := &hijackedConn{Conn: conn, stream: w.stream}
+	if rw.Reader.Buffered() == 0 {
+		rw.Reader.Reset(c)
+	}
+	if rw.Writer.Buffered() == 0 {
+		rw.Writer.Reset(c)
+	}
+	return c, rw, nil
+}
+
+// uncompressedWriter counts the bytes written by the handlers before they go
+// through a compression middleware.
+type uncompressedWriter struct {
+	gin.ResponseWriter
+	w *responseWriter
+}
+
+func (u *uncompressedWriter) Unwrap() http.ResponseWriter {
+	return u.ResponseWriter
+}
+
+func (u *uncompressedWriter) Write(data []byte) (int, error) {
+	n, err := u.ResponseWriter.Write(data)
+	u.w.uncompressed += int64(n)
+	return n, err
+}
+
+func (u *uncompressedWriter) WriteString(s string) (int, error) {
+	n, err := u.ResponseWriter.WriteString(s)
+	u.w.uncompressed += int64(n)
+	return n, err
+}
+
+// streamConn tracks a single long-lived connection. Its messages and bytes
+// are counted as they go, so they are visible while the connection is open.
+type streamConn struct {
+	p     *Prometheus
+	path  string
+	kind  string
+	start time.Time
+	once  sync.Once
+
+	messages prometheus.Counter
+	bytes    prometheus.Counter
+}
+
+// newStreamConn starts tracking a connection, its duration being measured
+// from the start of the request rather than from its detection.
+func newStreamConn(p *Prometheus, path, kind string, start time.Time) *streamConn {
+	p.activeConns.WithLabelValues(path, kind).Inc()
+	return &streamConn{
+		p:        p,
+		path:     path,
+		kind:     kind,
+		start:    start,
+		messages: p.streamMsgs.WithLabelValues(path, kind),
+		bytes:    p.streamBytes.WithLabelValues(path, kind),
+	}
+}
+
+func (s *streamConn) end() {
+	s.once.Do(func() {
+		s.p.activeConns.WithLabelValues(s.path, s.kind).Dec()
+		s.p.connDur.WithLabelValues(s.path, s.kind).Observe(time.Since(s.start).Seconds())
+	})
+}
+
+// hijackedConn counts the bytes and write calls going through a hijacked
+// connection and ends its tracking once it is closed.
+type hijackedConn struct {
+	net.Conn
+	stream *streamConn
+}
+
+func (c *hijackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stream.bytes.Add(float64(n))
+	return n, err
+}
+
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stream.bytes.Add(float64(n))
+	c.stream.messages.Inc()
+	return n, err
+}
+
+func (c *hijackedConn) Close() error {
+	c.stream.end()
+	return c.Conn.Close()
+}